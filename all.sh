@@ -17,13 +17,38 @@ case "$cmd" in
 	;;
     test)
 	go build -o "$d"/mkbundle/mkbundle "$d"/mkbundle
-        "$d"/mkbundle/mkbundle -v -g -pkg bundle_test \
+        "$d"/mkbundle/mkbundle -v -g -pkg bundle_test -names=func \
             -o="$d"/test_bundle_test.go "$d"/test_data
-	go test "$@" "$d"
+        "$d"/mkbundle/mkbundle -v -layout=blob -pkg bundle_test \
+            -names=const -prefix=BlobName \
+            -bundle _blobBundle -index _blobBundleIdx \
+            -o="$d"/test_blob_bundle_test.go "$d"/test_data
+        "$d"/mkbundle/mkbundle -v -stable -split=dir -pkg bundle_test \
+            -bundle _splitBundle -index _splitBundleIdx \
+            -o="$d"/test_split_bundle_test.go "$d"/test_data
+        "$d"/mkbundle/mkbundle -v -split=size -maxsize=200000 \
+            -pkg bundle_test -bundle _shardBundle -index _shardBundleIdx \
+            -o="$d"/test_shard_bundle_test.go "$d"/test_data
+        "$d"/mkbundle/mkbundle -v -dict=32768 -pkg bundle_test \
+            -bundle _dictBundle -index _dictBundleIdx \
+            -o="$d"/test_dict_bundle_test.go "$d"/test_data
+        "$d"/mkbundle/mkbundle -v -solid=100000 -pkg bundle_test \
+            -bundle _solidBundle -index _solidBundleIdx \
+            -o="$d"/test_solid_bundle_test.go "$d"/test_data
+        "$d"/mkbundle/mkbundle -v -g -format=bin \
+            -o="$d"/test_bundle.bin "$d"/test_data
+	go test "$@" "$d" "$d"/mkbundle
+	;;
+    sizes)
+	go build -o "$d"/mkbundle/mkbundle "$d"/mkbundle
+	(cd "$d"/mkbundle && go run mksizes.go ./mkbundle)
 	;;
     clean)
 	go clean "$@" "$d"/mkbundle "$d"
-	rm -f "$d"/test_bundle_test.go
+	rm -f "$d"/test_bundle_test.go "$d"/test_blob_bundle_test.go
+	rm -f "$d"/test_dict_bundle_test.go "$d"/test_solid_bundle_test.go
+	rm -f "$d"/test_split_bundle*_test.go "$d"/test_shard_bundle*_test.go
+	rm -f "$d"/test_bundle.bin
 	;;
     *)
 	echo "$0: Nothing to do for $cmd"
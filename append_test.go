@@ -0,0 +1,225 @@
+package bundle_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var self_prog = `package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/npat-efault/bundle"
+	"os"
+)
+
+func main() {
+	idx, err := bundle.OpenSelf()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, e := range idx.Dir("") {
+		data, err := e.Decode(0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s %d %x\n", e.Name, e.Size, sha256.Sum256(data))
+	}
+}
+`
+
+// buildProg writes the program source "src" and (optionally) the
+// source "bsrc" in a temporary directory, and builds the program
+// and the mkbundle command. Returns the paths of the two binaries.
+func buildProg(t *testing.T, src, bsrc []byte) (prog, mkb string) {
+	var out []byte
+	var err error
+
+	if runtime.GOOS != "linux" {
+		t.Skip("Test runs only on Linux")
+	}
+	if testing.Short() {
+		t.Skip("Skipping in short mode")
+	}
+	gocmd := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err = os.Stat(gocmd); err != nil {
+		t.Skipf("Go command not found: %s", err)
+	}
+	dir := t.TempDir()
+	prog = filepath.Join(dir, "prog")
+	mkb = filepath.Join(dir, "mkbundle")
+	files := []string{filepath.Join(dir, "prog.go")}
+	err = ioutil.WriteFile(files[0], src, 0644)
+	if err != nil {
+		t.Fatalf("WriteFile(): %s", err)
+	}
+	if bsrc != nil {
+		files = append(files, filepath.Join(dir, "bundle.go"))
+		err = ioutil.WriteFile(files[1], bsrc, 0644)
+		if err != nil {
+			t.Fatalf("WriteFile(): %s", err)
+		}
+	}
+	args := append([]string{"build", "-o", prog}, files...)
+	out, err = exec.Command(gocmd, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build prog: %s\n%s", err, out)
+	}
+	out, err = exec.Command(gocmd, "build", "-o", mkb,
+		"github.com/npat-efault/bundle/mkbundle").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build mkbundle: %s\n%s", err, out)
+	}
+	return prog, mkb
+}
+
+// progOutput returns the output expected from the test programs for
+// the files in directory "dir".
+func progOutput(t *testing.T, dir string) string {
+	var exp []string
+
+	entries, err := mkentries(dir)
+	if err != nil {
+		t.Fatalf("mkentries failed (%s): %s", dir, err)
+	}
+	for _, nm := range entries {
+		fdata, err := ioutil.ReadFile(filepath.Join(dir, nm))
+		if err != nil {
+			t.Fatalf("ReadFile(): %s", err)
+		}
+		exp = append(exp, fmt.Sprintf("%s %d %x",
+			nm, len(fdata), sha256.Sum256(fdata)))
+	}
+	return strings.Join(exp, "\n")
+}
+
+// runProg runs the program and checks its output.
+func runProg(t *testing.T, prog, exp string) {
+	out, err := exec.Command(prog).CombinedOutput()
+	if err != nil {
+		t.Fatalf("prog: %s\n%s", err, out)
+	}
+	if strings.TrimSpace(string(out)) != exp {
+		t.Fatalf("Bad prog output:\n%s\nExpected:\n%s", out, exp)
+	}
+}
+
+func TestOpenSelf(t *testing.T) {
+	var out []byte
+	var err error
+
+	prog, mkb := buildProg(t, []byte(self_prog), nil)
+	// Without a bundle
+	out, err = exec.Command(prog).CombinedOutput()
+	if err == nil {
+		t.Fatalf("prog without bundle succeeded: %s", out)
+	}
+	exp := progOutput(t, data_dir)
+
+	// Append twice, the second time replaces the first bundle
+	var size int64
+	for i := 0; i < 2; i++ {
+		out, err = exec.Command(mkb, "-format=bin", "-append", "-g",
+			"-o", prog, data_dir).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mkbundle -append: %s\n%s", err, out)
+		}
+		fi, err := os.Stat(prog)
+		if err != nil {
+			t.Fatalf("Stat(): %s", err)
+		}
+		if i > 0 && fi.Size() != size {
+			t.Fatalf("Size after re-append %d != %d",
+				fi.Size(), size)
+		}
+		size = fi.Size()
+		runProg(t, prog, exp)
+	}
+	// A failed append leaves the appended bundle intact
+	out, err = exec.Command(mkb, "-format=bin", "-append",
+		"-o", prog, data_dir+"nosuchdir").CombinedOutput()
+	if err == nil {
+		t.Fatalf("mkbundle -append of missing dir succeeded")
+	}
+	runProg(t, prog, exp)
+	// Replace appended bundle
+	out, err = exec.Command(mkb, "replace", prog,
+		data_dir+"text").CombinedOutput()
+	if err != nil {
+		t.Fatalf("mkbundle replace: %s\n%s", err, out)
+	}
+	runProg(t, prog, progOutput(t, data_dir+"text"))
+}
+
+var region_prog = `package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+)
+
+func main() {
+	for _, e := range _bundleIdx.Dir("") {
+		data, err := e.Decode(0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s %d %x\n", e.Name, e.Size, sha256.Sum256(data))
+	}
+}
+`
+
+func TestReplace(t *testing.T) {
+	var bsrc, out, before, after []byte
+	var err error
+
+	tdir := data_dir + "text"
+	bsrc, err = exec.Command("mkbundle/mkbundle", "-reserve=465000",
+		tdir).Output()
+	if err != nil {
+		t.Fatalf("mkbundle -reserve: %s", err)
+	}
+	prog, mkb := buildProg(t, []byte(region_prog), bsrc)
+	runProg(t, prog, progOutput(t, tdir))
+
+	// Replace with larger bundle
+	out, err = exec.Command(mkb, "replace", "-g", prog,
+		data_dir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("mkbundle replace: %s\n%s", err, out)
+	}
+	runProg(t, prog, progOutput(t, data_dir))
+
+	// Replace with a bundle that does not fit
+	before, err = ioutil.ReadFile(prog)
+	if err != nil {
+		t.Fatalf("ReadFile(): %s", err)
+	}
+	out, err = exec.Command(mkb, "replace", prog,
+		data_dir).CombinedOutput()
+	if err == nil {
+		t.Fatalf("mkbundle replace with large bundle succeeded")
+	}
+	t.Logf("mkbundle replace: %s", out)
+	after, err = ioutil.ReadFile(prog)
+	if err != nil {
+		t.Fatalf("ReadFile(): %s", err)
+	}
+	if bytes.Compare(before, after) != 0 {
+		t.Fatalf("Executable modified by failed replace")
+	}
+	runProg(t, prog, progOutput(t, data_dir))
+}
@@ -3,12 +3,22 @@
 package bundle
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
+	"hash"
 	"io"
+	"io/fs"
+	"path"
 	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unsafe"
 )
 
 // Falgs for *Entry.Open and *Entry.Decode
@@ -16,6 +26,15 @@ const (
 	NODC int = 1 << iota // Do not decompress data
 )
 
+// MaxSize, if greater than zero, is the maximum size of decoded
+// entry data. Attempts to decode or read entries larger than MaxSize
+// fail with an error wrapping ErrTooLarge. Independently of MaxSize,
+// reading more data than the entry size (Entry.Size) from an entry
+// also fails with such an error. This protects programs accessing
+// bundles from untrusted sources against "decompression bombs".
+// MaxSize should be set before any entries are accessed.
+var MaxSize int
+
 // The Entry type is used to represent the bundled file data. One such
 // structure is used for every bundled file. All structures are kept
 // in a global slice. When files are included in a bundle the terms
@@ -27,10 +46,41 @@ type Entry struct {
 	// The size of the entry in bytes (size of "Data"). This the
 	// original data size, before compression and encoding.
 	Size int
-	// Is the entry compressed?
+	// Is the entry compressed? Entry data are compressed with gzip,
+	// or (if Dict is set) with raw DEFLATE.
 	Gzip bool
+	// Preset dictionary for the DEFLATE compressor, if the entry is
+	// compressed with one, or empty. Dictionaries are built from the
+	// data of all the entries in the bundle, and shared by them; this
+	// improves compression considerably for many small, similar
+	// files.
+	Dict string
 	// Entry data compressed (if Gzip is true) and base64 encoded
 	Data string
+	// Entry data compressed (if Gzip is true) but not encoded. Used
+	// instead of Data by bundles generated with the "blob" layout,
+	// where Raw is a slice of a single string constant holding the
+	// data of all the entries in the bundle.
+	Raw string
+	// SHA-256 checksum of the decoded data (32 bytes), or empty if
+	// not known. If set, it is verified when the entry is decoded
+	// or read.
+	Sum string
+	// Target of a symbolic link entry (slash-separated, relative to
+	// the directory of the entry), or empty for other entries. Link
+	// entries have no data; they are resolved to their targets by
+	// Index lookups (see Index.Entry).
+	Link string
+	// File mode bits of the entry, if recorded, or zero. Recorded
+	// for directory entries (for which Mode.IsDir() is true). Like
+	// link entries, directory entries have no data.
+	Mode fs.FileMode
+	// The chunk holding the entry data, compressed together with
+	// the data of other entries, or nil. Entries in chunks have no
+	// data of their own (and are not compressed on their own).
+	Chunk *Chunk
+	// Offset of the entry data in the decompressed chunk data
+	Off int
 }
 
 // Index is the type of the global map of names to entries. Such a map
@@ -41,33 +91,263 @@ type Index map[string]*Entry
 // to MkIndex is inserted automatically by "mkbundle" to the "init"
 // function of the generated file.
 func MkIndex(bundle []Entry) Index {
-	var bsz int
 	var idx Index
 
-	bsz = len(bundle)
-	idx = make(Index, bsz)
-	for i := 0; i < bsz; i++ {
+	idx = make(Index, len(bundle))
+	idx.Add(bundle)
+	return idx
+}
+
+// The Add method adds the entries in slice "bundle" to the index.
+// Entries with the same names as entries already in the index
+// replace them. Calls to Add are inserted automatically by
+// "mkbundle -split" to the "init" functions of the generated part
+// files.
+func (idx Index) Add(bundle []Entry) {
+	for i := 0; i < len(bundle); i++ {
 		idx[bundle[i].Name] = &bundle[i]
 	}
+}
+
+// A LazyIndex builds an index on first use. Bundles generated by
+// "mkbundle -lazy" keep their entries in separate variables, and use
+// a LazyIndex to build the index only if (and when) the program asks
+// for it. This way the linker can drop the entries the program does
+// not reference. The zero value of LazyIndex is ready to use. A
+// LazyIndex is safe for concurrent use by multiple goroutines.
+type LazyIndex struct {
+	once sync.Once
+	idx  Index
+}
+
+// The Index method returns the index. On the first call, the index is
+// built from the entries returned by function "entries". Subsequent
+// calls return the same index, and do not call "entries".
+func (l *LazyIndex) Index(entries func() []*Entry) Index {
+	l.once.Do(func() {
+		el := entries()
+		l.idx = make(Index, len(el))
+		for _, e := range el {
+			l.idx[e.Name] = e
+		}
+	})
+	return l.idx
+}
+
+var registry struct {
+	mu sync.Mutex
+	m  map[string]Index
+}
+
+// Register adds the entries in slice "bundle" to the index registered
+// under "name" (creating it, if required). Calls to Register are
+// inserted automatically by "mkbundle -split=pkg" to the "init"
+// functions of the generated files, so that bundles split in several
+// packages are merged in a single index. Register must not be called
+// after the program has started using the index.
+func Register(name string, bundle []Entry) {
+	Registered(name).Add(bundle)
+}
+
+// Registered returns the index registered under "name" (creating an
+// empty one, if required). Since packages are initialized before the
+// packages importing them, an index returned by Registered during
+// the initialization of a package has the entries registered by all
+// the packages it imports.
+func Registered(name string) Index {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.m == nil {
+		registry.m = make(map[string]Index)
+	}
+	idx, ok := registry.m[name]
+	if !ok {
+		idx = make(Index)
+		registry.m[name] = idx
+	}
 	return idx
 }
 
+// Maximum number of link entries followed when resolving a name
+const maxLinks = 40
+
+// resolveName returns the name that "name" refers to, following
+// link entries, both for the entry itself and for the directories in
+// its name. The name returned is that of an entry that is not a link,
+// or a name without an entry (e.g. of a directory without a directory
+// entry, or of a missing entry). Returns false if a link points
+// outside the bundle, or if too many links are encountered (e.g.
+// because of a cycle).
+func (idx Index) resolveName(name string) (string, bool) {
+	for n := 0; n <= maxLinks; n++ {
+		e, ok := idx[name]
+		if ok && e.Link == "" {
+			return name, true
+		}
+		if ok {
+			name, ok = linkTarget(name, e.Link)
+			if !ok {
+				return "", false
+			}
+			continue
+		}
+		// Look for a link to a directory in the name
+		found := false
+		for i := 0; i < len(name); i++ {
+			if name[i] != '/' {
+				continue
+			}
+			if d, ok := idx[name[:i]]; ok && d.Link != "" {
+				t, ok := linkTarget(name[:i], d.Link)
+				if !ok {
+					return "", false
+				}
+				name, found = path.Join(t, name[i+1:]), true
+				break
+			}
+		}
+		if !found {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// resolve returns the entry with the given name, following link
+// entries (see resolveName). Returns nil if no such entry exists, if
+// a link points outside the bundle, or if too many links are
+// encountered.
+func (idx Index) resolve(name string) *Entry {
+	name, ok := idx.resolveName(name)
+	if !ok {
+		return nil
+	}
+	return idx[name]
+}
+
+// linkTarget returns the name of the target of link entry "name",
+// which points to "link". Returns false if the target is outside the
+// bundle.
+func linkTarget(name, link string) (string, bool) {
+	if path.IsAbs(link) {
+		return "", false
+	}
+	t := path.Join(path.Dir(name), link)
+	if t == ".." || strings.HasPrefix(t, "../") {
+		return "", false
+	}
+	return t, true
+}
+
 // The Has method returns true if the bundle has an entry with the
-// given name
+// given name. Like Entry, it resolves link entries.
 func (idx Index) Has(name string) bool {
-	_, ok := idx[name]
-	return ok
+	return idx.resolve(name) != nil
 }
 
 // The Entry method returns a pointer to the entry with the requested
 // name, if such an entry exists in the bundle, or nil if no such
-// entry exists.
+// entry exists. Link entries, for the entry or for the directories
+// in its name, are resolved: The entry returned is the target of the
+// link(s) (and nil is returned for dangling links). To get the link
+// entries themselves, access the Index map directly.
 func (idx Index) Entry(name string) *Entry {
-	e, ok := idx[name]
+	return idx.resolve(name)
+}
+
+// The Open method opens the entry with the given name for reading,
+// like Entry.Open(0) does. Link entries are resolved like by
+// Index.Entry. If no such entry exists in the bundle, it returns an
+// *fs.PathError wrapping fs.ErrNotExist.
+func (idx Index) Open(name string) (*Reader, error) {
+	e := idx.resolve(name)
+	if e == nil {
+		return nil, errNotExist("open", name)
+	}
+	return e.Open(0)
+}
+
+// entryInfo is the fs.FileInfo returned by Index.Stat
+type entryInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (fi *entryInfo) Name() string       { return fi.name }
+func (fi *entryInfo) Size() int64        { return fi.size }
+func (fi *entryInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *entryInfo) ModTime() time.Time { return time.Time{} }
+func (fi *entryInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *entryInfo) Sys() interface{}   { return nil }
+
+// The Stat method returns an fs.FileInfo describing the entry with
+// the given name. Link entries are resolved like by Index.Entry.
+// Names of directories are reported as directories, whether there
+// is a directory entry for them, or not (if there are entries under
+// them). The mode of directories without directory entries, and of
+// entries without recorded modes, is read-only (0555 for
+// directories, 0444 for files). The modification time is not
+// recorded in bundles, and is reported as zero. A trailing slash in
+// the name is ignored, but then the name must be of a directory. If
+// no such entry or directory exists in the bundle, Stat returns an
+// *fs.PathError wrapping fs.ErrNotExist.
+func (idx Index) Stat(name string) (fs.FileInfo, error) {
+	_, fi, err := idx.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// stat returns the entry with the given name (nil for directories
+// without directory entries), and an fs.FileInfo describing it, for
+// Index.Stat. Argument "op" is the operation reported in errors.
+func (idx Index) stat(op, name string) (*Entry, *entryInfo, error) {
+	var fi *entryInfo
+
+	fi = &entryInfo{name: path.Base(name)}
+	dir := strings.TrimRight(name, "/")
+	r, ok := idx.resolveName(dir)
 	if !ok {
-		return nil
+		return nil, nil, errNotExist(op, name)
+	}
+	e := idx[r]
+	switch {
+	case e != nil:
+		fi.size, fi.mode = int64(e.Size), e.Mode
+		if fi.mode.Perm() == 0 {
+			fi.mode |= 0444
+			if fi.mode.IsDir() {
+				fi.mode |= 0111
+			}
+		}
+	case r == "" || r == "." || idx.hasDir(r):
+		fi.mode = fs.ModeDir | 0555
+	default:
+		return nil, nil, errNotExist(op, name)
+	}
+	if dir != name && !fi.IsDir() {
+		return nil, nil, &fs.PathError{Op: op, Path: name,
+			Err: errNotDir}
+	}
+	return e, fi, nil
+}
+
+// hasDir reports if there are entries under directory "dir"
+func (idx Index) hasDir(dir string) bool {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for nm := range idx {
+		if strings.HasPrefix(nm, prefix) {
+			return true
+		}
 	}
-	return e
+	return false
+}
+
+// The IsDir method reports whether "e" is a directory entry
+func (e *Entry) IsDir() bool {
+	return e.Mode.IsDir()
 }
 
 // Dir is a slice of pointers to entries. It implements sort.Interface
@@ -91,11 +371,16 @@ func (d Dir) Less(i, j int) bool {
 }
 
 // The Dir method returns a Dir (slice of Entry pointers) of all the
-// entries whose names match the given prefix (all entries whose names
-// start with string "prefix")
+// file entries whose names match the given prefix (all entries whose
+// names start with string "prefix"). Directory and link entries, which
+// have no data, are not included; use the Index map directly, or the
+// file system returned by FS, to list them.
 func (idx Index) Dir(prefix string) []*Entry {
 	var dir Dir
 	for _, e := range idx {
+		if e.Link != "" || e.IsDir() {
+			continue
+		}
 		if strings.HasPrefix(e.Name, prefix) {
 			dir = append(dir, e)
 		}
@@ -104,68 +389,315 @@ func (idx Index) Dir(prefix string) []*Entry {
 	return dir
 }
 
+// The Sub method returns a new index (a view) containing only the
+// entries under directory "dir". Names in the returned index are
+// relative to "dir" (e.g. entry "plugins/foo/conf.json" is named
+// "conf.json" in the index returned by Sub("plugins/foo")). Entries
+// outside the subtree are not visible through the view. The entries
+// of the view are copies of the original entries (with adjusted
+// names), but share their data with them. If "dir" is empty or ".",
+// a view of the whole index is returned.
+func (idx Index) Sub(dir string) Index {
+	var sub Index
+	var prefix string
+
+	dir = path.Clean("/" + dir)[1:]
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	sub = make(Index)
+	for _, e := range idx {
+		if !strings.HasPrefix(e.Name, prefix) {
+			continue
+		}
+		se := *e
+		se.Name = e.Name[len(prefix):]
+		sub[se.Name] = &se
+	}
+	return sub
+}
+
+// source returns a reader for the entry data, decoded but not
+// decompressed, and the maximum number of bytes that can be read
+// from it.
+func (e *Entry) source() (io.Reader, int) {
+	var r64 io.Reader
+
+	if e.Data == "" {
+		return strings.NewReader(e.Raw), len(e.Raw)
+	}
+	r64 = base64.NewDecoder(base64.StdEncoding, strings.NewReader(e.Data))
+	return r64, base64.StdEncoding.DecodedLen(len(e.Data))
+}
+
 // Decode returns the decoded data for the bundle entry. Returns a
 // slice of bytes with the decoded, decompressed (if required), ready
 // to use entry data, and an error indication which is not-nil if the
-// data cannot be decoded. If argument "flag" is NODC, and the entry
-// data are compressed (Entry.Gzip == true), Decode will not
-// decompress the data it returns (it will only decode them). In most
-// cases it is preferable to use the Reader interface instead of
-// calling Decode.
+// data cannot be decoded (the error wraps ErrCorrupt), or if they are
+// larger than the entry size or MaxSize (the error wraps
+// ErrTooLarge). If argument "flag" is NODC, and the entry data are
+// compressed (Entry.Gzip == true), Decode will not decompress the
+// data it returns (it will only decode them). In most cases it is
+// preferable to use the Reader interface instead of calling Decode.
 func (e *Entry) Decode(flag int) ([]byte, error) {
-	var rs *strings.Reader
-	var r64 io.Reader
-	var rz *gzip.Reader
+	var r io.Reader
+	var rz *zReader
 	var buf *bytes.Buffer
+	var data []byte
+	var n int
 	var err error
 
-	rs = strings.NewReader(e.Data)
-	r64 = base64.NewDecoder(base64.StdEncoding, rs)
-	if e.Gzip && (flag&NODC == 0) {
-		rz, err = gzip.NewReader(r64)
+	if e.Link != "" || e.IsDir() {
+		return nil, errNotRegular("decode", e)
+	}
+	if e.Chunk != nil {
+		return e.decodeChunk()
+	}
+	r, n = e.source()
+	if e.Gzip && (flag&NODC != 0) {
+		// Leave room for the final (EOF) read
+		buf = bytes.NewBuffer(make([]byte, 0, n+bytes.MinRead))
+		_, err = io.Copy(buf, r)
 		if err != nil {
+			return nil, errCorrupt("decode", e.Name, err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	if MaxSize > 0 && e.Size > MaxSize {
+		return nil, errTooLarge("decode", e.Name)
+	}
+	if e.Gzip {
+		rz, err = getZReader(r, e.Dict)
+		if err != nil {
+			return nil, errCorrupt("decode", e.Name, err)
+		}
+		defer putZReader(rz)
+		r = rz.r
+	}
+	data, err = readSized(r, e.Size, n, e.Gzip)
+	if err == ErrTooLarge {
+		return nil, errTooLarge("decode", e.Name)
+	} else if err != nil {
+		return nil, errCorrupt("decode", e.Name, err)
+	}
+	if e.Sum != "" {
+		sum := sha256.Sum256(data)
+		if string(sum[:]) != e.Sum {
+			return nil, errCorrupt("decode", e.Name, errChecksum)
+		}
+	}
+	return data, nil
+}
+
+// Bounds for the buffer allocated by readSized before reading: The
+// maximum DEFLATE compression ratio, and a fixed size beyond which the
+// buffer grows only as data are read.
+const (
+	maxRatio    int = 1032
+	maxPrealloc int = 1 << 20
+)
+
+// readSized reads exactly "size" bytes from "r", and makes sure there
+// are no more. Argument "stored" is the maximum number of (stored)
+// bytes the data are read from, and "zip" tells if they are
+// compressed. As "size" comes from the bundle, which may not be
+// trusted, the buffer is not allocated up-front: It starts at a size
+// that the stored data can possibly produce (and at most
+// maxPrealloc), and grows as data are read. Returns ErrTooLarge if
+// there are more data, or io.ErrUnexpectedEOF if there are fewer.
+func readSized(r io.Reader, size, stored int, zip bool) ([]byte, error) {
+	var data []byte
+	var n int
+	var err error
+
+	if size < 0 {
+		return nil, errors.New("negative size")
+	}
+	n = size
+	if !zip && n > stored {
+		n = stored
+	} else if zip && stored <= maxInt/maxRatio && n > stored*maxRatio {
+		n = stored * maxRatio
+	}
+	if n > maxPrealloc {
+		n = maxPrealloc
+	}
+	data = make([]byte, 0, n)
+	for len(data) < size {
+		if len(data) == cap(data) {
+			n = size
+			if cap(data) < size/2-bytes.MinRead {
+				n = 2*cap(data) + bytes.MinRead
+			}
+			data = append(make([]byte, 0, n), data...)
+		}
+		n, err = r.Read(data[len(data):cap(data)])
+		data = data[:len(data)+n]
+		if err == io.EOF && len(data) < size {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil && err != io.EOF {
 			return nil, err
 		}
-		defer rz.Close()
-	} else {
-		rz = nil
 	}
-	buf = new(bytes.Buffer)
-	if rz != nil {
-		_, err = io.Copy(buf, rz)
+	// Make sure there are no more data
+	_, err = io.ReadFull(r, make([]byte, 1))
+	if err == nil {
+		return nil, ErrTooLarge
+	} else if err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// decodeChunk returns a copy of the data of entry "e", which is in a
+// chunk.
+func (e *Entry) decodeChunk() ([]byte, error) {
+	if MaxSize > 0 && e.Size > MaxSize {
+		return nil, errTooLarge("decode", e.Name)
+	}
+	data, err := e.chunkData()
+	if err != nil {
+		return nil, errCorrupt("decode", e.Name, err)
+	}
+	if e.Sum != "" {
+		sum := sha256.Sum256(data)
+		if string(sum[:]) != e.Sum {
+			return nil, errCorrupt("decode", e.Name, errChecksum)
+		}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// The Direct method returns the entry data as a string, without
+// decoding or copying them. This is possible only for uncompressed
+// entries of bundles generated with the "blob" layout. For all other
+// entries (including entries in chunks) Direct returns an empty
+// string and false.
+func (e *Entry) Direct() (string, bool) {
+	if e.Data != "" || e.Gzip || e.Chunk != nil {
+		return "", false
+	}
+	return e.Raw, true
+}
+
+// The DirectBytes method is like Direct, but returns the entry data
+// as a slice of bytes. The returned slice shares memory with the
+// (immutable) data of the bundle, and must not be modified.
+func (e *Entry) DirectBytes() ([]byte, bool) {
+	s, ok := e.Direct()
+	if !ok || s == "" {
+		return nil, ok
+	}
+	return unsafe.Slice(unsafe.StringData(s), len(s)), true
+}
+
+// zReader is a gzip (or DEFLATE) reader together with the buffered
+// reader that feeds it. Both are reused (through zPool) to avoid
+// allocating their (considerable) state every time an entry is
+// decompressed.
+type zReader struct {
+	br *bufio.Reader
+	zr *gzip.Reader
+	fr io.ReadCloser // DEFLATE reader, for entries with a dictionary
+	r  io.ReadCloser // The one of zr or fr in use
+}
+
+var zPool sync.Pool
+
+// getZReader returns a, possibly recycled, zReader that decompresses
+// data read from "r". If "dict" is not empty, the data are raw
+// DEFLATE, compressed with preset dictionary "dict"; otherwise they
+// are gzip'ed.
+func getZReader(r io.Reader, dict string) (*zReader, error) {
+	var rz *zReader
+	var err error
+
+	rz, _ = zPool.Get().(*zReader)
+	if rz == nil {
+		rz = &zReader{br: bufio.NewReader(nil), zr: new(gzip.Reader)}
+	}
+	rz.br.Reset(r)
+	if dict == "" {
+		err = rz.zr.Reset(rz.br)
+		rz.r = rz.zr
 	} else {
-		_, err = io.Copy(buf, r64)
+		// The reader copies the dictionary, it does not modify it
+		d := unsafe.Slice(unsafe.StringData(dict), len(dict))
+		if rz.fr == nil {
+			rz.fr = flate.NewReaderDict(rz.br, d)
+		} else {
+			err = rz.fr.(flate.Resetter).Reset(rz.br, d)
+		}
+		rz.r = rz.fr
 	}
 	if err != nil {
+		putZReader(rz)
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return rz, nil
+}
+
+// putZReader returns "rz" to the pool.
+func putZReader(rz *zReader) {
+	rz.br.Reset(nil)
+	zPool.Put(rz)
 }
 
 // A Reader implents the io.Reader and io.Closer interface by reading,
 // decoding, and decompressing (if required) data from a bundle entry.
 type Reader struct {
-	rs  *strings.Reader
-	r64 io.Reader
-	rz  *gzip.Reader
+	name   string
+	r      io.Reader
+	rz     *zReader
+	n      int // Bytes read so far
+	max    int // Max bytes allowed, or -1 for no limit
+	h      hash.Hash
+	sum    string
+	closed bool
 }
 
 // Open intializes and returns a Reader that reads from the bundle
-// entry. It returns an error if the reader cannot be initialized. If
-// argument "flag" is NODC, and the entry data are compressed
-// (Entry.Gzip == true), the reader will not decompress the data read
-// from it (it will only decode them).
+// entry. It returns an error if the reader cannot be initialized
+// (e.g. if the compressed data are corrupt, in which case the error
+// wraps ErrCorrupt, or if the entry is larger than MaxSize, in which
+// case the error wraps ErrTooLarge). If argument "flag" is NODC, and
+// the entry data are compressed (Entry.Gzip == true), the reader will
+// not decompress the data read from it (it will only decode them).
 func (e *Entry) Open(flag int) (*Reader, error) {
 	var br *Reader
 	var err error
 
-	br = &Reader{}
-	br.rs = strings.NewReader(e.Data)
-	br.r64 = base64.NewDecoder(base64.StdEncoding, br.rs)
-	if e.Gzip && (flag&NODC == 0) {
-		br.rz, err = gzip.NewReader(br.r64)
+	if e.Link != "" || e.IsDir() {
+		return nil, errNotRegular("open", e)
+	}
+	br = &Reader{name: e.Name, max: -1}
+	if e.Chunk != nil {
+		if MaxSize > 0 && e.Size > MaxSize {
+			return nil, errTooLarge("open", e.Name)
+		}
+		data, err := e.chunkData()
 		if err != nil {
-			return nil, err
+			return nil, errCorrupt("open", e.Name, err)
+		}
+		br.r = bytes.NewReader(data)
+	} else {
+		br.r, _ = e.source()
+	}
+	if e.Gzip && (flag&NODC != 0) {
+		return br, nil
+	}
+	if MaxSize > 0 && e.Size > MaxSize {
+		return nil, errTooLarge("open", e.Name)
+	}
+	br.max = e.Size
+	if e.Sum != "" {
+		br.h, br.sum = sha256.New(), e.Sum
+	}
+	if e.Gzip {
+		br.rz, err = getZReader(br.r, e.Dict)
+		if err != nil {
+			return nil, errCorrupt("open", e.Name, err)
 		}
 	} else {
 		br.rz = nil
@@ -176,23 +708,64 @@ func (e *Entry) Open(flag int) (*Reader, error) {
 // The Read method is used to read data from a bundle entry. Read
 // fills slice "p" with decoded, decompressed, ready to use
 // data. Returns the number of bytes read (stored in "p") and an error
-// indication (which is not-nil when a read error has occured).
+// indication (which is not-nil when a read error has occured). Read
+// errors, other than io.EOF, wrap ErrCorrupt, or ErrTooLarge if
+// more data than the entry size are available.
 func (br *Reader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+
+	if br.closed {
+		return 0, &fs.PathError{Op: "read", Path: br.name,
+			Err: fs.ErrClosed}
+	}
+	if br.max >= 0 && len(p) > br.max-br.n+1 {
+		// Read at most one byte more than allowed
+		p = p[:br.max-br.n+1]
+	}
 	if br.rz != nil {
-		return br.rz.Read(p)
+		n, err = br.rz.r.Read(p)
 	} else {
-		return br.r64.Read(p)
+		n, err = br.r.Read(p)
+	}
+	br.n += n
+	if br.max >= 0 && br.n > br.max {
+		n -= br.n - br.max
+		br.n = br.max
+		return n, errTooLarge("read", br.name)
+	}
+	if br.h != nil {
+		br.h.Write(p[:n])
+		if err == io.EOF && string(br.h.Sum(nil)) != br.sum {
+			err = errChecksum
+		}
 	}
+	if err != nil && err != io.EOF {
+		err = errCorrupt("read", br.name, err)
+	}
+	return n, err
 }
 
 // The Close method is Used to terminate the operation of the
 // Reader. Returns an error indication which is not-nil if an error
-// has occured during close. After calling Close no other operations
-// must be performed on this Reader.
+// has occured during close. After calling Close, Read and Close
+// return an error wrapping fs.ErrClosed.
 func (br *Reader) Close() error {
+	var err error
+
+	if br.closed {
+		return &fs.PathError{Op: "close", Path: br.name,
+			Err: fs.ErrClosed}
+	}
+	br.closed = true
+	br.r = nil
 	if br.rz != nil {
-		return br.rz.Close()
-	} else {
-		return nil
+		err = br.rz.r.Close()
+		putZReader(br.rz)
+		br.rz = nil
+	}
+	if err != nil {
+		return errCorrupt("close", br.name, err)
 	}
+	return nil
 }
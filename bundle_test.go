@@ -2,11 +2,17 @@ package bundle_test
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"encoding/base64"
+	"errors"
 	"github.com/npat-efault/bundle"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -66,7 +72,7 @@ func TestIndex(t *testing.T) {
 		d = _bundleIdx.Dir(entries[i])
 		e = _bundleIdx.Entry(entries[i])
 		if e == nil {
-			t.Fatal("Cannot find entry: %s", entries[0])
+			t.Fatalf("Cannot find entry: %s", entries[0])
 		}
 		// A name may be a prefix of another name!
 		if len(d) < 1 {
@@ -149,7 +155,7 @@ func TestCompressed(t *testing.T) {
 	var e *bundle.Entry
 	var br *bundle.Reader
 	var gr *gzip.Reader
-	var data, rdata, fdata, ddata []byte
+	var data, fdata, ddata []byte
 	var i int
 	var err error
 
@@ -179,11 +185,11 @@ func TestCompressed(t *testing.T) {
 		}
 		ddata, err = ioutil.ReadAll(gr)
 		if err != nil {
-			t.Fatalf("ReadAll(gr): %s")
+			t.Fatalf("ReadAll(gr): %s", err)
 		}
 		err = gr.Close()
 		if err != nil {
-			t.Fatalf("gr.Close(): %s")
+			t.Fatalf("gr.Close(): %s", err)
 		}
 		if len(ddata) != e.Size {
 			t.Fatalf("len(ddata) %d != e.Size %d",
@@ -202,19 +208,19 @@ func TestCompressed(t *testing.T) {
 		}
 		gr, err = gzip.NewReader(br)
 		if err != nil {
-			t.Fatalf("gzip.NewReader(br): %s")
+			t.Fatalf("gzip.NewReader(br): %s", err)
 		}
 		ddata, err = ioutil.ReadAll(gr)
 		if err != nil {
-			t.Fatalf("ReadAll(gr): %s")
+			t.Fatalf("ReadAll(gr): %s", err)
 		}
 		err = gr.Close()
 		if err != nil {
-			t.Fatalf("gr.Close(): %s")
+			t.Fatalf("gr.Close(): %s", err)
 		}
 		if len(ddata) != e.Size {
 			t.Fatalf("len(ddata) %d != e.Size %d",
-				len(rdata), e.Size)
+				len(ddata), e.Size)
 		}
 		if len(ddata) != len(fdata) {
 			t.Fatalf("Bad ddata sz for: %s", entries[i])
@@ -226,3 +232,463 @@ func TestCompressed(t *testing.T) {
 			e.Name, e.Size, e.Gzip)
 	}
 }
+
+func TestSub(t *testing.T) {
+	var sub bundle.Index
+	var e, se *bundle.Entry
+	var data, sdata []byte
+	var err error
+
+	sub = _bundleIdx.Sub("text/locales/")
+	if sz := len(sub); sz != 2 {
+		t.Fatalf("Sub index size %d != 2", sz)
+	}
+	if sub.Has("readme.txt") || sub.Has("text/readme.txt") {
+		t.Fatalf("Entry outside subtree visible in Sub index")
+	}
+	if d := sub.Dir("en/"); len(d) != 1 || d[0].Name != "en/messages.json" {
+		t.Fatalf("Bad Dir result for Sub index: %v", d)
+	}
+	se = sub.Entry("el/messages.json")
+	if se == nil {
+		t.Fatalf("Entry not found in Sub index: el/messages.json")
+	}
+	e = _bundleIdx.Entry("text/locales/el/messages.json")
+	if e.Name != "text/locales/el/messages.json" {
+		t.Fatalf("Sub modified original entry name: %s", e.Name)
+	}
+	data, err = e.Decode(0)
+	if err != nil {
+		t.Fatalf("e.Decode(): %s", err)
+	}
+	sdata, err = se.Decode(0)
+	if err != nil {
+		t.Fatalf("se.Decode(): %s", err)
+	}
+	if bytes.Compare(data, sdata) != 0 {
+		t.Fatalf("Bad data for Sub entry: %s", se.Name)
+	}
+	// Views of views
+	sub = _bundleIdx.Sub("text").Sub("locales/en")
+	if !sub.Has("messages.json") || len(sub) != 1 {
+		t.Fatalf("Bad Sub of Sub index: %v", sub.Dir(""))
+	}
+	if sz := len(_bundleIdx.Sub("")); sz != len(_bundleIdx) {
+		t.Fatalf("Sub(\"\") size %d != %d", sz, len(_bundleIdx))
+	}
+	if sz := len(_bundleIdx.Sub("nosuchdir")); sz != 0 {
+		t.Fatalf("Sub(\"nosuchdir\") size %d != 0", sz)
+	}
+}
+
+// TestAllocs checks that the decompressors of compressed entries are
+// pooled: Decoding or reading an entry must allocate less than
+// decompressing its data with a new gzip.Reader.
+func TestAllocs(t *testing.T) {
+	var e *bundle.Entry
+	var pooled, unpooled float64
+
+	e = _bundleIdx.Entry("text/locales/el/messages.json")
+	if !e.Gzip {
+		t.Skip("Entry not compressed")
+	}
+	// newReader returns a new (not pooled) reader of the entry data
+	newReader := func() io.Reader {
+		zr, err := gzip.NewReader(base64.NewDecoder(
+			base64.StdEncoding, strings.NewReader(e.Data)))
+		if err != nil {
+			t.Fatalf("gzip.NewReader(): %s", err)
+		}
+		return zr
+	}
+	// Warm-up the pools
+	_, _ = e.Decode(0)
+	pooled = testing.AllocsPerRun(100, func() {
+		if _, err := e.Decode(0); err != nil {
+			t.Fatalf("e.Decode(): %s", err)
+		}
+	})
+	unpooled = testing.AllocsPerRun(100, func() {
+		data := make([]byte, e.Size)
+		if _, err := io.ReadFull(newReader(), data); err != nil {
+			t.Fatalf("io.ReadFull(): %s", err)
+		}
+	})
+	t.Logf("Decode: %v allocs, %v without pool", pooled, unpooled)
+	if pooled >= unpooled {
+		t.Fatalf("Decode: %v allocs, %v without pool",
+			pooled, unpooled)
+	}
+	pooled = testing.AllocsPerRun(100, func() {
+		br, err := e.Open(0)
+		if err != nil {
+			t.Fatalf("e.Open(): %s", err)
+		}
+		if _, err = io.Copy(ioutil.Discard, br); err != nil {
+			t.Fatalf("io.Copy(): %s", err)
+		}
+		br.Close()
+	})
+	unpooled = testing.AllocsPerRun(100, func() {
+		if _, err := io.Copy(ioutil.Discard, newReader()); err != nil {
+			t.Fatalf("io.Copy(): %s", err)
+		}
+	})
+	t.Logf("Open/Read/Close: %v allocs, %v without pool",
+		pooled, unpooled)
+	if pooled >= unpooled {
+		t.Fatalf("Open/Read/Close: %v allocs, %v without pool",
+			pooled, unpooled)
+	}
+}
+
+// TestClosed checks that Readers return fs.ErrClosed when used after
+// Close, for compressed and uncompressed entries.
+func TestClosed(t *testing.T) {
+	const nm = "text/readme.txt"
+
+	for _, idx := range []bundle.Index{_bundleIdx, _blobBundleIdx} {
+		br, err := idx.Open(nm)
+		if err != nil {
+			t.Fatalf("Open(): %s", err)
+		}
+		br.Close()
+		gz := idx.Entry(nm).Gzip
+		if _, err = br.Read(make([]byte, 8)); !errors.Is(err,
+			fs.ErrClosed) {
+			t.Fatalf("Read after Close (gzip %v): %v", gz, err)
+		}
+		if err = br.Close(); !errors.Is(err, fs.ErrClosed) {
+			t.Fatalf("Close after Close (gzip %v): %v", gz, err)
+		}
+	}
+}
+
+func benchmarkOpen(b *testing.B, name string) {
+	e := _bundleIdx.Entry(name)
+	b.SetBytes(int64(e.Size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br, err := e.Open(0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = io.Copy(ioutil.Discard, br); err != nil {
+			b.Fatal(err)
+		}
+		br.Close()
+	}
+}
+
+func BenchmarkOpenText(b *testing.B) {
+	benchmarkOpen(b, "text/locales/el/messages.json")
+}
+
+func BenchmarkOpenImage(b *testing.B) {
+	benchmarkOpen(b, "car-sw.jpg")
+}
+
+func TestBlob(t *testing.T) {
+	var entries []string
+	var e *bundle.Entry
+	var br *bundle.Reader
+	var data, rdata, fdata []byte
+	var s string
+	var ok bool
+	var err error
+
+	entries, err = mkentries(data_dir)
+	if err != nil {
+		t.Fatalf("mkentries failed (%s): %s", data_dir, err)
+	}
+	if sz := len(_blobBundleIdx); sz != len(entries) {
+		t.Fatalf("Index size %d != %d", sz, len(entries))
+	}
+	for _, nm := range entries {
+		fdata, err = ioutil.ReadFile(data_dir + nm)
+		if err != nil {
+			t.Fatalf("ReadFile(): %s", err)
+		}
+		e = _blobBundleIdx.Entry(nm)
+		if e == nil {
+			t.Fatalf("Entry not found: %s", nm)
+		}
+		s, ok = e.Direct()
+		if !ok {
+			t.Fatalf("Direct() failed for: %s", nm)
+		}
+		if s != string(fdata) {
+			t.Fatalf("Bad Direct() data for: %s", nm)
+		}
+		data, ok = e.DirectBytes()
+		if !ok || bytes.Compare(data, fdata) != 0 {
+			t.Fatalf("Bad DirectBytes() data for: %s", nm)
+		}
+		data, err = e.Decode(0)
+		if err != nil {
+			t.Fatalf("bundle.Decode(): %s", err)
+		}
+		if bytes.Compare(data, fdata) != 0 {
+			t.Fatalf("Bad data for: %s", nm)
+		}
+		br, err = e.Open(0)
+		if err != nil {
+			t.Fatalf("e.Open(): %s", err)
+		}
+		rdata, err = ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("ReadAll(br): %s", err)
+		}
+		br.Close()
+		if bytes.Compare(rdata, fdata) != 0 {
+			t.Fatalf("Bad rdata for: %s", nm)
+		}
+		// Compressed entries cannot be accessed directly
+		if _, ok = _bundleIdx.Entry(nm).Direct(); ok {
+			t.Fatalf("Direct() succeeded for: %s", nm)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	checkIndex(t, _splitBundleIdx)
+}
+
+func TestDict(t *testing.T) {
+	var entries []string
+	var data, fdata []byte
+	var br *bundle.Reader
+	var err error
+
+	checkIndex(t, _dictBundleIdx)
+	entries, err = mkentries(data_dir)
+	if err != nil {
+		t.Fatalf("mkentries failed (%s): %s", data_dir, err)
+	}
+	for _, nm := range entries {
+		fdata, err = ioutil.ReadFile(data_dir + nm)
+		if err != nil {
+			t.Fatalf("ReadFile(): %s", err)
+		}
+		e := _dictBundleIdx.Entry(nm)
+		if !e.Gzip || e.Dict == "" {
+			t.Fatalf("%s: No dictionary", nm)
+		}
+		// Read with bundle.Reader
+		br, err = e.Open(0)
+		if err != nil {
+			t.Fatalf("e.Open(): %s", err)
+		}
+		data, err = ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("ReadAll(br): %s", err)
+		}
+		err = br.Close()
+		if err != nil {
+			t.Fatalf("br.Close(): %s", err)
+		}
+		if !bytes.Equal(data, fdata) {
+			t.Fatalf("Bad data for: %s", nm)
+		}
+		// Decompress (raw DEFLATE) data
+		data, err = e.Decode(bundle.NODC)
+		if err != nil {
+			t.Fatalf("bundle.Decode(): %s", err)
+		}
+		data, err = ioutil.ReadAll(flate.NewReaderDict(
+			bytes.NewReader(data), []byte(e.Dict)))
+		if err != nil {
+			t.Fatalf("ReadAll(flate): %s", err)
+		}
+		if !bytes.Equal(data, fdata) {
+			t.Fatalf("Bad DEFLATE data for: %s", nm)
+		}
+	}
+}
+
+func TestSolid(t *testing.T) {
+	var entries []string
+	var data, fdata []byte
+	var br *bundle.Reader
+	var err error
+
+	checkIndex(t, _solidBundleIdx)
+	entries, err = mkentries(data_dir)
+	if err != nil {
+		t.Fatalf("mkentries failed (%s): %s", data_dir, err)
+	}
+	chunks := make(map[*bundle.Chunk]int)
+	defer func(sz int) { bundle.ChunkCacheSize = sz }(bundle.ChunkCacheSize)
+	for _, sz := range []int{0, 1 << 20} {
+		bundle.ChunkCacheSize = sz
+		for _, nm := range entries {
+			fdata, err = ioutil.ReadFile(data_dir + nm)
+			if err != nil {
+				t.Fatalf("ReadFile(): %s", err)
+			}
+			e := _solidBundleIdx.Entry(nm)
+			if e.Chunk == nil {
+				t.Fatalf("%s: Not in a chunk", nm)
+			}
+			chunks[e.Chunk]++
+			br, err = e.Open(0)
+			if err != nil {
+				t.Fatalf("e.Open(): %s", err)
+			}
+			data, err = ioutil.ReadAll(br)
+			if err != nil {
+				t.Fatalf("ReadAll(br): %s", err)
+			}
+			err = br.Close()
+			if err != nil {
+				t.Fatalf("br.Close(): %s", err)
+			}
+			if !bytes.Equal(data, fdata) {
+				t.Fatalf("Bad data for: %s", nm)
+			}
+		}
+	}
+	if len(chunks) < 2 || len(chunks) == len(entries) {
+		t.Fatalf("%d entries in %d chunks", len(entries), len(chunks))
+	}
+}
+
+func TestAdd(t *testing.T) {
+	var idx bundle.Index
+
+	idx = bundle.MkIndex([]bundle.Entry{
+		{Name: "a", Size: 1, Data: "YQ=="},
+		{Name: "b", Size: 1, Data: "Yg=="},
+	})
+	idx.Add([]bundle.Entry{
+		{Name: "b", Size: 1, Data: "Qg=="},
+		{Name: "c", Size: 1, Data: "Yw=="},
+	})
+	if len(idx) != 3 {
+		t.Fatalf("Index size %d != 3", len(idx))
+	}
+	for nm, d := range map[string]string{"a": "a", "b": "B", "c": "c"} {
+		data, err := idx.Entry(nm).Decode(0)
+		if err != nil {
+			t.Fatalf("Decode(%s): %s", nm, err)
+		}
+		if string(data) != d {
+			t.Fatalf("Bad data for %s: %q != %q", nm, data, d)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	var e *bundle.Entry
+
+	e = AssetTextReadmeTXT()
+	if e == nil || e.Name != "text/readme.txt" {
+		t.Fatalf("Bad entry for AssetTextReadmeTXT(): %v", e)
+	}
+	e = AssetDonPeterJPEG()
+	if e == nil || e.Name != "don+peter.jpeg" {
+		t.Fatalf("Bad entry for AssetDonPeterJPEG(): %v", e)
+	}
+	if BlobNameTextLocalesElMessagesJSON != "text/locales/el/messages.json" {
+		t.Fatalf("Bad name: %s", BlobNameTextLocalesElMessagesJSON)
+	}
+	if !_blobBundleIdx.Has(BlobNameCarSwJPG) {
+		t.Fatalf("Entry not found: %s", BlobNameCarSwJPG)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var pe *fs.PathError
+	var err error
+
+	_, err = _bundleIdx.Open("nosuchentry")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Open missing entry: %v", err)
+	}
+	if !errors.As(err, &pe) || pe.Path != "nosuchentry" {
+		t.Fatalf("Open missing entry: not a PathError: %v", err)
+	}
+	br, err := _bundleIdx.Open("text/readme.txt")
+	if err != nil {
+		t.Fatalf("Open(): %s", err)
+	}
+	br.Close()
+
+	var bad = []bundle.Entry{
+		// Bad gzip header
+		{Name: "bad1", Size: 10, Gzip: true, Data: "AAAAAAAAAAAA"},
+		// Bad base64
+		{Name: "bad2", Size: 10, Gzip: false, Data: "!!!!"},
+		// Truncated gzip stream
+		{Name: "bad3", Size: 10, Gzip: true, Raw: "\x1f\x8b\x08\x00"},
+	}
+	for i := range bad {
+		e := &bad[i]
+		_, err = e.Decode(0)
+		if !errors.Is(err, bundle.ErrCorrupt) {
+			t.Fatalf("Decode %s: %v", e.Name, err)
+		}
+		if !errors.As(err, &pe) || pe.Path != e.Name {
+			t.Fatalf("Decode %s: not a PathError: %v", e.Name, err)
+		}
+		t.Logf("Decode %s: %v", e.Name, err)
+		br, err = e.Open(0)
+		if err == nil {
+			_, err = ioutil.ReadAll(br)
+		}
+		if !errors.Is(err, bundle.ErrCorrupt) {
+			t.Fatalf("Open/Read %s: %v", e.Name, err)
+		}
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	var br *bundle.Reader
+	var data []byte
+	var err error
+
+	// A "decompression bomb": 1MB of zeros, claiming to be 10 bytes
+	zw := gzip.NewWriter(&buf)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+	e := &bundle.Entry{Name: "bomb", Size: 10, Gzip: true,
+		Raw: buf.String()}
+	_, err = e.Decode(0)
+	if !errors.Is(err, bundle.ErrTooLarge) {
+		t.Fatalf("Decode: %v", err)
+	}
+	br, err = e.Open(0)
+	if err != nil {
+		t.Fatalf("Open(): %s", err)
+	}
+	data, err = ioutil.ReadAll(br)
+	if !errors.Is(err, bundle.ErrTooLarge) {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(data) != e.Size {
+		t.Fatalf("Read %d bytes != %d", len(data), e.Size)
+	}
+	br.Close()
+	// Compressed data can still be accessed
+	data, err = e.Decode(bundle.NODC)
+	if err != nil || len(data) != buf.Len() {
+		t.Fatalf("Decode(NODC): %d bytes, %v", len(data), err)
+	}
+
+	// Global limit
+	e = _bundleIdx.Entry("car-sw.jpg")
+	defer func(max int) { bundle.MaxSize = max }(bundle.MaxSize)
+	bundle.MaxSize = e.Size - 1
+	if _, err = e.Decode(0); !errors.Is(err, bundle.ErrTooLarge) {
+		t.Fatalf("Decode with MaxSize: %v", err)
+	}
+	if _, err = e.Open(0); !errors.Is(err, bundle.ErrTooLarge) {
+		t.Fatalf("Open with MaxSize: %v", err)
+	}
+	bundle.MaxSize = e.Size
+	if _, err = e.Decode(0); err != nil {
+		t.Fatalf("Decode with MaxSize: %v", err)
+	}
+}
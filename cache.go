@@ -0,0 +1,119 @@
+// Cache of decoded entry data
+
+package bundle
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A Cache keeps the decoded (and decompressed) data of recently used
+// entries of an index, so that repeated accesses to the same
+// ("hot") entries do not have to decode them again. The total size
+// of the data kept in the cache is limited by a byte budget. When the
+// budget is exceeded, the least recently used entries are dropped
+// from the cache. A Cache is safe for concurrent use by multiple
+// goroutines.
+type Cache struct {
+	idx    Index
+	max    int
+	mu     sync.Mutex
+	lru    *list.List // of *cacheItem, most recently used first
+	items  map[*Entry]*list.Element
+	size   int
+	hits   uint64
+	misses uint64
+}
+
+type cacheItem struct {
+	e    *Entry
+	data []byte
+}
+
+// NewCache returns a cache for the entries of index "idx" that keeps
+// at most "max" bytes of decoded data. Entries larger than "max" are
+// never cached.
+func NewCache(idx Index, max int) *Cache {
+	return &Cache{
+		idx:   idx,
+		max:   max,
+		lru:   list.New(),
+		items: make(map[*Entry]*list.Element),
+	}
+}
+
+// The Decode method returns the decoded data for the entry with the
+// given name, like Entry.Decode(0) does. If the data are in the
+// cache, they are returned without decoding the entry again. The
+// returned slice is shared with the cache and with other callers,
+// and must not be modified. Returns an error if no such entry exists
+// in the index (wrapping fs.ErrNotExist), or if the entry data
+// cannot be decoded (wrapping ErrCorrupt).
+func (c *Cache) Decode(name string) ([]byte, error) {
+	var e *Entry
+	var data []byte
+	var err error
+
+	e = c.idx.Entry(name)
+	if e == nil {
+		return nil, errNotExist("decode", name)
+	}
+	c.mu.Lock()
+	if el, ok := c.items[e]; ok {
+		c.lru.MoveToFront(el)
+		c.hits++
+		data = el.Value.(*cacheItem).data
+		c.mu.Unlock()
+		return data, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	data, err = e.Decode(0)
+	if err != nil {
+		return nil, err
+	}
+	c.add(e, data)
+	return data, nil
+}
+
+// add inserts the data of entry "e" in the cache, dropping least
+// recently used entries as required to stay within budget.
+func (c *Cache) add(e *Entry, data []byte) {
+	if len(data) > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[e]; ok {
+		// Added by another goroutine, meanwhile
+		return
+	}
+	for c.size+len(data) > c.max {
+		el := c.lru.Back()
+		it := c.lru.Remove(el).(*cacheItem)
+		delete(c.items, it.e)
+		c.size -= len(it.data)
+	}
+	c.items[e] = c.lru.PushFront(&cacheItem{e: e, data: data})
+	c.size += len(data)
+}
+
+// The Purge method drops all entries from the cache. The hit and
+// miss counters are not reset.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	c.lru.Init()
+	c.items = make(map[*Entry]*list.Element)
+	c.size = 0
+	c.mu.Unlock()
+}
+
+// The Stats method returns the number of cache hits and misses
+// since the cache was created, and the number of bytes currently
+// kept in the cache.
+func (c *Cache) Stats() (hits, misses uint64, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.size
+}
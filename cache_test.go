@@ -0,0 +1,131 @@
+package bundle_test
+
+import (
+	"bytes"
+	"github.com/npat-efault/bundle"
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	var c *bundle.Cache
+	var data, cdata []byte
+	var hits, misses uint64
+	var size int
+	var err error
+
+	const name = "text/readme.txt"
+	e := _bundleIdx.Entry(name)
+	data, err = e.Decode(0)
+	if err != nil {
+		t.Fatalf("e.Decode(): %s", err)
+	}
+	c = bundle.NewCache(_bundleIdx, 2*e.Size)
+	for i := 0; i < 3; i++ {
+		cdata, err = c.Decode(name)
+		if err != nil {
+			t.Fatalf("c.Decode(): %s", err)
+		}
+		if bytes.Compare(data, cdata) != 0 {
+			t.Fatalf("Bad cached data for: %s", name)
+		}
+	}
+	hits, misses, size = c.Stats()
+	if hits != 2 || misses != 1 || size != e.Size {
+		t.Fatalf("Bad stats: hits %d, misses %d, size %d",
+			hits, misses, size)
+	}
+	// Entries larger than the budget are not cached
+	_, err = c.Decode("car-sw.jpg")
+	if err != nil {
+		t.Fatalf("c.Decode(): %s", err)
+	}
+	if _, _, size = c.Stats(); size != e.Size {
+		t.Fatalf("Size %d != %d", size, e.Size)
+	}
+	// Evict least recently used
+	_, _ = c.Decode("text/locales/en/messages.json")
+	_, _ = c.Decode("text/locales/el/messages.json")
+	_, _ = c.Decode(name)
+	if _, _, size = c.Stats(); size > 2*e.Size {
+		t.Fatalf("Size %d exceeds budget %d", size, 2*e.Size)
+	}
+	c.Purge()
+	if _, _, size = c.Stats(); size != 0 {
+		t.Fatalf("Size %d != 0 after Purge", size)
+	}
+	if _, err = c.Decode("nosuchentry"); err == nil {
+		t.Fatalf("No error for missing entry")
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+
+	c := bundle.NewCache(_bundleIdx, 1<<20)
+	entries := _bundleIdx.Dir("")
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				e := entries[(g+i)%len(entries)]
+				data, err := c.Decode(e.Name)
+				if err != nil {
+					t.Errorf("c.Decode(): %s", err)
+					return
+				}
+				if len(data) != e.Size {
+					t.Errorf("Bad data size for: %s",
+						e.Name)
+					return
+				}
+				if i%50 == 0 {
+					c.Purge()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func benchmarkDecode(b *testing.B, name string) {
+	e := _bundleIdx.Entry(name)
+	b.SetBytes(int64(e.Size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.Decode(0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkCacheDecode(b *testing.B, name string) {
+	c := bundle.NewCache(_bundleIdx, 1<<20)
+	e := _bundleIdx.Entry(name)
+	b.SetBytes(int64(e.Size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Decode(name); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeText(b *testing.B) {
+	benchmarkDecode(b, "text/locales/el/messages.json")
+}
+
+func BenchmarkCacheDecodeText(b *testing.B) {
+	benchmarkCacheDecode(b, "text/locales/el/messages.json")
+}
+
+func BenchmarkDecodeImage(b *testing.B) {
+	benchmarkDecode(b, "car-sw.jpg")
+}
+
+func BenchmarkCacheDecodeImage(b *testing.B) {
+	benchmarkCacheDecode(b, "car-sw.jpg")
+}
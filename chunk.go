@@ -0,0 +1,120 @@
+// Chunks of entries compressed together (solid bundles)
+
+package bundle
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// ChunkCacheSize, if greater than zero, is the maximum total size of
+// the decompressed chunks (see type Chunk) kept in memory. Accessing
+// an entry in a chunk requires decompressing the whole chunk (but
+// only that chunk); with the cache, accesses to entries in recently
+// used chunks do not. When the cache is full, the least recently used
+// chunks are dropped from it. Chunks larger than ChunkCacheSize are
+// never cached. ChunkCacheSize should be set before any entries are
+// accessed.
+var ChunkCacheSize int
+
+// A Chunk holds the data of several consecutive entries of a bundle,
+// compressed together (with gzip). Many small entries compress much
+// better together than every one on its own. Bundles with chunks are
+// generated by mkbundle's "-solid" flag. The entries in a chunk refer
+// to it (see field Entry.Chunk), and record the offset of their data
+// in the decompressed chunk (field Entry.Off).
+type Chunk struct {
+	// Size of the decompressed chunk data
+	Size int
+	// Chunk data, compressed and base64 encoded
+	Data string
+	// Chunk data, compressed but not encoded. Used instead of Data
+	// by bundles generated with the "blob" layout.
+	Raw string
+}
+
+var errChunk = errors.New("entry outside of its chunk")
+
+// chunkCache is the cache of decompressed chunks (see ChunkCacheSize)
+var chunkCache struct {
+	mu    sync.Mutex
+	lru   list.List // of *Chunk, most recently used first
+	items map[*Chunk]*chunkItem
+	size  int
+}
+
+type chunkItem struct {
+	el   *list.Element
+	data []byte
+}
+
+// decode returns the decompressed data of the chunk. The returned
+// slice may be shared with the chunk cache, and must not be modified.
+func (c *Chunk) decode() ([]byte, error) {
+	var rz *zReader
+	var data []byte
+	var err error
+
+	chunkCache.mu.Lock()
+	if it, ok := chunkCache.items[c]; ok {
+		chunkCache.lru.MoveToFront(it.el)
+		chunkCache.mu.Unlock()
+		return it.data, nil
+	}
+	chunkCache.mu.Unlock()
+
+	ce := Entry{Size: c.Size, Gzip: true, Data: c.Data, Raw: c.Raw}
+	r, n := ce.source()
+	rz, err = getZReader(r, "")
+	if err != nil {
+		return nil, err
+	}
+	defer putZReader(rz)
+	data, err = readSized(rz.r, c.Size, n, true)
+	if err != nil {
+		return nil, err
+	}
+	c.cache(data)
+	return data, nil
+}
+
+// cache adds the decompressed chunk data "data" to the chunk cache,
+// dropping least recently used chunks as required to stay within
+// ChunkCacheSize.
+func (c *Chunk) cache(data []byte) {
+	if len(data) > ChunkCacheSize {
+		return
+	}
+	chunkCache.mu.Lock()
+	defer chunkCache.mu.Unlock()
+	if chunkCache.items == nil {
+		chunkCache.items = make(map[*Chunk]*chunkItem)
+	}
+	if _, ok := chunkCache.items[c]; ok {
+		// Added by another goroutine, meanwhile
+		return
+	}
+	for chunkCache.size+len(data) > ChunkCacheSize {
+		old := chunkCache.lru.Remove(chunkCache.lru.Back()).(*Chunk)
+		chunkCache.size -= len(chunkCache.items[old].data)
+		delete(chunkCache.items, old)
+	}
+	chunkCache.items[c] = &chunkItem{chunkCache.lru.PushFront(c), data}
+	chunkCache.size += len(data)
+}
+
+// chunkData returns the data of entry "e", which is in a chunk. The
+// returned slice may be shared with the chunk cache, and must not be
+// modified.
+func (e *Entry) chunkData() ([]byte, error) {
+	data, err := e.Chunk.decode()
+	if err != nil {
+		return nil, err
+	}
+	if e.Off < 0 || e.Size < 0 || e.Off > len(data) ||
+		e.Size > len(data)-e.Off {
+		return nil, errChunk
+	}
+	return data[e.Off : e.Off+e.Size], nil
+}
@@ -0,0 +1,369 @@
+// Loading bundles from binary container files
+
+package bundle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// Bundles can also be stored in binary container files, which can be
+// loaded at runtime (see Load and LoadFile), instead of being
+// compiled in the program. Container files are generated by the
+// "mkbundle" command (see flag "-format"). A container consists of a
+// fixed-size header, followed by the index, followed by the data
+// region:
+//
+//	header:
+//	  magic    [8]byte    ContainerMagic
+//	  version  uint32     ContainerVersion
+//	  count    uint32     Number of entries
+//	  indexOff uint64     Offset of the index
+//	  indexLen uint64     Length of the index
+//	  dataOff  uint64     Offset of the data region
+//	  dataLen  uint64     Length of the data region
+//	index: "count" records, one for each entry:
+//	  nameLen  uint16     Length of the entry name
+//	  name     [nameLen]byte
+//	  codec    uint8      CodecNone, CodecGzip, CodecLink, CodecDir
+//	  size     uint64     Size of the decoded entry data (for
+//	                      CodecDir, the mode bits, except ModeDir)
+//	  off      uint64     Offset of the stored data in the data region
+//	  len      uint64     Length of the stored data
+//	  sum      [32]byte   SHA-256 of the decoded entry data
+//	data region:
+//	  Stored (possibly compressed) data of all entries
+//
+// All integers are little-endian. Offsets in the header are from the
+// start of the container. The data region starts at an offset that
+// is a multiple of ContainerAlign, so that it can be memory-mapped.
+const (
+	ContainerMagic      string = "GOBUNDLE"
+	ContainerVersion    uint32 = 1
+	ContainerHeaderSize int    = 48
+	ContainerAlign      int    = 4096
+)
+
+// Codecs for the data of container entries
+const (
+	CodecNone byte = iota // Not compressed
+	CodecGzip             // Compressed with gzip
+	CodecLink             // Link entry, data is the link target
+	CodecDir              // Directory entry, size is the mode
+)
+
+// Size of the fixed part of a container index record
+const containerRecSize = 2 + 1 + 8 + 8 + 8 + sha256.Size
+
+// errFormat returns an error wrapping ErrFormat.
+func errFormat(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrFormat, fmt.Sprintf(format, a...))
+}
+
+// Load reads the bundle container of size "size" from "r" and returns
+// an index of its entries. The data of all entries are read in
+// memory. Returns an error wrapping ErrFormat if the container is
+// malformed.
+func Load(r io.ReaderAt, size int64) (Index, error) {
+	return load(r, size, func(off, n int64) (string, error) {
+		return readData(r, off, n)
+	})
+}
+
+// LoadFile is like Load but reads the bundle container from the named
+// file. Where supported, the file's data region is memory-mapped
+// instead of being read in memory. In this case the file must not be
+// modified (or truncated) while the returned index is in use: Doing
+// so may crash the program (with SIGBUS). To update such a file,
+// replace it (e.g. by renaming a new file over it) instead. The
+// mapping remains until Unmap is called for the index.
+func LoadFile(name string) (Index, error) {
+	return loadFile(name, false)
+}
+
+// Unmap releases the memory-mapping of the data region of index
+// "idx", returned by LoadFile, LoadAppended, or OpenSelf. After
+// calling Unmap, neither the index, nor any index derived from it
+// (e.g. by Sub), nor readers opened on their entries, may be used.
+// Unmap does nothing if the data of the index are not memory-mapped.
+func Unmap(idx Index) error {
+	for _, e := range idx {
+		if e.Raw != "" {
+			return unmapData(e.Raw)
+		}
+		if e.Link != "" {
+			return unmapData(e.Link)
+		}
+	}
+	return nil
+}
+
+// LoadAppended is like LoadFile, but loads the bundle container
+// appended to the named file (usually an executable) by "mkbundle
+// -append". The container is located using the trailer at the end of
+// the file:
+//
+//	trailer:
+//	  off      uint64     Offset of the container in the file
+//	  len      uint64     Length of the container
+//	  magic    [8]byte    TrailerMagic
+//
+// Returns an error wrapping ErrFormat if no container is appended to
+// the file.
+func LoadAppended(name string) (Index, error) {
+	return loadFile(name, true)
+}
+
+// OpenSelf returns the index of the bundle container appended to the
+// executable of the running program (see LoadAppended).
+func OpenSelf() (Index, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return LoadAppended(exe)
+}
+
+// Appended-container trailer
+const (
+	TrailerMagic string = "GOBNDEND"
+	TrailerSize  int    = 24
+)
+
+// ReadTrailer reads the trailer at the end of "r" (of size "size"),
+// and returns the offset and the length of the appended bundle
+// container. Returns an error wrapping ErrFormat if there is no
+// trailer, or if it is malformed.
+func ReadTrailer(r io.ReaderAt, size int64) (off, n int64, err error) {
+	var tr [TrailerSize]byte
+	var le = binary.LittleEndian
+
+	if size < int64(TrailerSize) {
+		return 0, 0, errFormat("no appended bundle")
+	}
+	_, err = r.ReadAt(tr[:], size-int64(TrailerSize))
+	if err != nil {
+		return 0, 0, err
+	}
+	if string(tr[16:]) != TrailerMagic {
+		return 0, 0, errFormat("no appended bundle")
+	}
+	uoff, un := le.Uint64(tr[0:]), le.Uint64(tr[8:])
+	if uoff > uint64(size) || un > uint64(size)-uoff ||
+		uoff+un > uint64(size-int64(TrailerSize)) {
+		return 0, 0, errFormat("bad trailer")
+	}
+	return int64(uoff), int64(un), nil
+}
+
+// loadFile loads the container stored in, or (if "appended" is true)
+// appended to, the named file.
+func loadFile(name string, appended bool) (Index, error) {
+	var f *os.File
+	var fi os.FileInfo
+	var idx Index
+	var ds string
+	var off, size int64
+	var err error
+
+	f, err = os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// Closing the file does not unmap it
+	defer f.Close()
+	fi, err = f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size = fi.Size()
+	if appended {
+		off, size, err = ReadTrailer(f, size)
+		if err != nil {
+			return nil, &fs.PathError{Op: "load", Path: name,
+				Err: err}
+		}
+	}
+	idx, err = load(io.NewSectionReader(f, off, size), size,
+		func(doff, n int64) (string, error) {
+			ds, err = mapData(f, off+doff, n)
+			return ds, err
+		})
+	if err != nil {
+		_ = unmapData(ds)
+		return nil, &fs.PathError{Op: "load", Path: name, Err: err}
+	}
+	return idx, nil
+}
+
+// Bundles generated by "mkbundle -reserve=N" are stored as bundle
+// containers in a fixed-size region of the program's data (N bytes
+// long). The data of the bundle can later be replaced directly in the
+// executable ("mkbundle replace"), without rebuilding the program, as
+// long as the new container fits in the region. The region has the
+// following layout:
+//
+//	header:
+//	  magic    [8]byte    RegionMagic
+//	  size     uint64     Size of the region, including the header
+//	  len      uint64     Length of the container
+//	container:
+//	  [len]byte           The bundle container
+//	padding:
+//	  [size-len-RegionHeaderSize]byte   Zeros
+//
+// All integers are little-endian.
+const (
+	RegionMagic      string = "GOBNDRSV"
+	RegionHeaderSize int    = 24
+)
+
+// LoadRegion returns the index of the bundle container stored in the
+// reserved region "r". The entries of the returned index refer
+// directly to the data in the region, which must not be modified
+// afterwards. A call to LoadRegion is inserted automatically by
+// "mkbundle -reserve" to the "init" function of the generated file.
+// Returns an error wrapping ErrFormat if the region is malformed.
+func LoadRegion(r []byte) (Index, error) {
+	var le = binary.LittleEndian
+	var c []byte
+
+	if len(r) < RegionHeaderSize || string(r[:8]) != RegionMagic {
+		return nil, errFormat("bad region header")
+	}
+	size, n := le.Uint64(r[8:]), le.Uint64(r[16:])
+	if size != uint64(len(r)) ||
+		n > uint64(len(r)-RegionHeaderSize) {
+		return nil, errFormat("bad region size or length")
+	}
+	c = r[RegionHeaderSize : RegionHeaderSize+int(n)]
+	return load(bytes.NewReader(c), int64(len(c)),
+		func(off, n int64) (string, error) {
+			if n == 0 {
+				return "", nil
+			}
+			return unsafe.String(&c[off], int(n)), nil
+		})
+}
+
+// readData reads "n" bytes from "r", starting at offset "off", and
+// returns them as a string.
+func readData(r io.ReaderAt, off, n int64) (string, error) {
+	var sb strings.Builder
+
+	sb.Grow(int(n))
+	_, err := io.Copy(&sb, io.NewSectionReader(r, off, n))
+	if err != nil {
+		return "", err
+	}
+	if int64(sb.Len()) != n {
+		return "", errFormat("short data region")
+	}
+	return sb.String(), nil
+}
+
+// load parses the container header and index, read from "r", and
+// returns the index. It uses function "data" to get the contents of
+// the data region.
+func load(r io.ReaderAt, size int64,
+	data func(off, n int64) (string, error)) (Index, error) {
+	var hdr [ContainerHeaderSize]byte
+	var le = binary.LittleEndian
+	var ib []byte
+	var ds string
+	var bundle []Entry
+	var err error
+
+	if size < int64(ContainerHeaderSize) {
+		return nil, errFormat("short header")
+	}
+	_, err = r.ReadAt(hdr[:], 0)
+	if err != nil {
+		return nil, err
+	}
+	if string(hdr[:8]) != ContainerMagic {
+		return nil, errFormat("bad magic")
+	}
+	if v := le.Uint32(hdr[8:]); v != ContainerVersion {
+		return nil, errFormat("unsupported version %d", v)
+	}
+	count := uint64(le.Uint32(hdr[12:]))
+	idxOff, idxLen := le.Uint64(hdr[16:]), le.Uint64(hdr[24:])
+	dataOff, dataLen := le.Uint64(hdr[32:]), le.Uint64(hdr[40:])
+	if idxOff > uint64(size) || idxLen > uint64(size)-idxOff {
+		return nil, errFormat("bad index offset or length")
+	}
+	if dataOff > uint64(size) || dataLen > uint64(size)-dataOff {
+		return nil, errFormat("bad data offset or length")
+	}
+	if count*containerRecSize > idxLen {
+		return nil, errFormat("bad entry count %d", count)
+	}
+
+	ib = make([]byte, idxLen)
+	_, err = r.ReadAt(ib, int64(idxOff))
+	if err != nil {
+		return nil, err
+	}
+	ds, err = data(int64(dataOff), int64(dataLen))
+	if err != nil {
+		return nil, err
+	}
+	bundle = make([]Entry, count)
+	for i := range bundle {
+		e := &bundle[i]
+		if len(ib) < containerRecSize {
+			return nil, errFormat("short index")
+		}
+		nl := int(le.Uint16(ib))
+		if len(ib) < containerRecSize+nl {
+			return nil, errFormat("short index")
+		}
+		e.Name = string(ib[2 : 2+nl])
+		ib = ib[2+nl:]
+		switch ib[0] {
+		case CodecNone, CodecLink, CodecDir:
+			e.Gzip = false
+		case CodecGzip:
+			e.Gzip = true
+		default:
+			return nil, errFormat("%s: unknown codec %d",
+				e.Name, ib[0])
+		}
+		sz, off, n := le.Uint64(ib[1:]), le.Uint64(ib[9:]),
+			le.Uint64(ib[17:])
+		if sz > uint64(maxInt) {
+			return nil, errFormat("%s: bad size", e.Name)
+		}
+		if off > dataLen || n > dataLen-off {
+			return nil, errFormat("%s: bad offset or length",
+				e.Name)
+		}
+		// Reject sizes the stored data cannot produce
+		if ib[0] == CodecNone && sz != n ||
+			ib[0] == CodecGzip && n <= uint64(maxInt/maxRatio) &&
+				sz > n*uint64(maxRatio) {
+			return nil, errFormat("%s: bad size", e.Name)
+		}
+		if ib[0] == CodecLink {
+			e.Link = ds[off : off+n]
+		} else if ib[0] == CodecDir {
+			e.Mode = fs.FileMode(sz) | fs.ModeDir
+		} else {
+			e.Size = int(sz)
+			e.Raw = ds[off : off+n]
+			e.Sum = string(ib[25 : 25+sha256.Size])
+		}
+		ib = ib[25+sha256.Size:]
+	}
+	return MkIndex(bundle), nil
+}
+
+const maxInt = int(^uint(0) >> 1)
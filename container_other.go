@@ -0,0 +1,20 @@
+//go:build !unix
+
+package bundle
+
+import (
+	"os"
+)
+
+// mapData reads "n" bytes of file "f", starting at offset "off", and
+// returns them as a string. Memory-mapping is not supported on this
+// system.
+func mapData(f *os.File, off, n int64) (string, error) {
+	return readData(f, off, n)
+}
+
+// unmapData does nothing, as data are never memory-mapped on this
+// system.
+func unmapData(s string) error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package bundle_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/npat-efault/bundle"
+	"io/ioutil"
+	"testing"
+)
+
+var container_file = "test_bundle.bin"
+
+func checkIndex(t *testing.T, idx bundle.Index) {
+	var entries []string
+	var data, fdata []byte
+	var err error
+
+	entries, err = mkentries(data_dir)
+	if err != nil {
+		t.Fatalf("mkentries failed (%s): %s", data_dir, err)
+	}
+	if sz := len(idx); sz != len(entries) {
+		t.Fatalf("Index size %d != %d", sz, len(entries))
+	}
+	for _, nm := range entries {
+		fdata, err = ioutil.ReadFile(data_dir + nm)
+		if err != nil {
+			t.Fatalf("ReadFile(): %s", err)
+		}
+		e := idx.Entry(nm)
+		if e == nil {
+			t.Fatalf("Entry not found: %s", nm)
+		}
+		if e.Size != len(fdata) {
+			t.Fatalf("e.Size %d != %d", e.Size, len(fdata))
+		}
+		data, err = e.Decode(0)
+		if err != nil {
+			t.Fatalf("e.Decode(): %s", err)
+		}
+		if bytes.Compare(data, fdata) != 0 {
+			t.Fatalf("Bad data for: %s", nm)
+		}
+		br, err := idx.Open(nm)
+		if err != nil {
+			t.Fatalf("Open(): %s", err)
+		}
+		data, err = ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("ReadAll(br): %s", err)
+		}
+		br.Close()
+		if bytes.Compare(data, fdata) != 0 {
+			t.Fatalf("Bad rdata for: %s", nm)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	idx, err := bundle.LoadFile(container_file)
+	if err != nil {
+		t.Fatalf("LoadFile(): %s", err)
+	}
+	checkIndex(t, idx)
+	if err = bundle.Unmap(idx); err != nil {
+		t.Fatalf("Unmap(): %s", err)
+	}
+	// Already unmapped, or not mapped at all
+	if err = bundle.Unmap(idx); err != nil {
+		t.Fatalf("Unmap() again: %s", err)
+	}
+	if err = bundle.Unmap(_bundleIdx); err != nil {
+		t.Fatalf("Unmap() of compiled-in index: %s", err)
+	}
+	checkIndex(t, _bundleIdx)
+}
+
+func TestLoad(t *testing.T) {
+	b, err := ioutil.ReadFile(container_file)
+	if err != nil {
+		t.Fatalf("ReadFile(): %s", err)
+	}
+	idx, err := bundle.Load(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Load(): %s", err)
+	}
+	checkIndex(t, idx)
+
+	// Malformed containers
+	for _, n := range []int{0, 10, bundle.ContainerHeaderSize,
+		bundle.ContainerAlign, len(b) - 1} {
+		_, err = bundle.Load(bytes.NewReader(b[:n]), int64(n))
+		if !errors.Is(err, bundle.ErrFormat) {
+			t.Fatalf("Load() truncated at %d: %v", n, err)
+		}
+	}
+	bb := append([]byte(nil), b...)
+	bb[0] = 'X'
+	_, err = bundle.Load(bytes.NewReader(bb), int64(len(bb)))
+	if !errors.Is(err, bundle.ErrFormat) {
+		t.Fatalf("Load() with bad magic: %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var err error
+
+	e := *_bundleIdx.Entry("text/readme.txt")
+	e.Sum = "01234567890123456789012345678901"
+	if _, err = e.Decode(0); !errors.Is(err, bundle.ErrCorrupt) {
+		t.Fatalf("Decode with bad checksum: %v", err)
+	}
+	br, err := e.Open(0)
+	if err != nil {
+		t.Fatalf("Open(): %s", err)
+	}
+	_, err = ioutil.ReadAll(br)
+	if !errors.Is(err, bundle.ErrCorrupt) {
+		t.Fatalf("ReadAll with bad checksum: %v", err)
+	}
+}
+
+func TestBadSize(t *testing.T) {
+	var entries []bundle.Entry
+	var err error
+
+	// Sizes that the stored data cannot produce must fail without
+	// allocating them.
+	entries = []bundle.Entry{{Name: "raw", Size: 3, Raw: "abc"}}
+	for _, e := range _bundleIdx {
+		if e.Gzip {
+			entries = append(entries, *e)
+			break
+		}
+	}
+	for _, e := range entries {
+		e.Size = int(^uint(0) >> 1)
+		if _, err = e.Decode(0); !errors.Is(err, bundle.ErrCorrupt) {
+			t.Fatalf("Decode %s with bad size: %v", e.Name, err)
+		}
+		e.Size = -1
+		if _, err = e.Decode(0); !errors.Is(err, bundle.ErrCorrupt) {
+			t.Fatalf("Decode %s with negative size: %v", e.Name, err)
+		}
+	}
+}
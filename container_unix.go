@@ -0,0 +1,64 @@
+//go:build unix
+
+package bundle
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Memory-mapped data regions, by the address of their first byte
+var mapped struct {
+	sync.Mutex
+	m map[*byte][]byte
+}
+
+// mapData memory-maps "n" bytes of file "f", starting at offset
+// "off", and returns them as a string. If the file cannot be
+// mapped, the data are read instead.
+func mapData(f *os.File, off, n int64) (string, error) {
+	var b []byte
+	var err error
+
+	if n == 0 {
+		return "", nil
+	}
+	start := off &^ int64(os.Getpagesize()-1)
+	if off-start+n > int64(maxInt) {
+		return readData(f, off, n)
+	}
+	b, err = syscall.Mmap(int(f.Fd()), start, int(off-start+n),
+		syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return readData(f, off, n)
+	}
+	mapped.Lock()
+	if mapped.m == nil {
+		mapped.m = make(map[*byte][]byte)
+	}
+	mapped.m[&b[0]] = b
+	mapped.Unlock()
+	b = b[off-start:]
+	return unsafe.String(&b[0], len(b)), nil
+}
+
+// unmapData unmaps the memory-mapped data region (if any) that
+// string "s" points into.
+func unmapData(s string) error {
+	if len(s) == 0 {
+		return nil
+	}
+	p := uintptr(unsafe.Pointer(unsafe.StringData(s)))
+	mapped.Lock()
+	defer mapped.Unlock()
+	for k, b := range mapped.m {
+		start := uintptr(unsafe.Pointer(k))
+		if p >= start && p < start+uintptr(len(b)) {
+			delete(mapped.m, k)
+			return syscall.Munmap(b)
+		}
+	}
+	return nil
+}
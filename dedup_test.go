@@ -0,0 +1,93 @@
+package bundle_test
+
+import (
+	"github.com/npat-efault/bundle"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestDedup checks that mkbundle emits the data of identical files
+// once, and that all the entries sharing them decode correctly.
+func TestDedup(t *testing.T) {
+	var icon, other []byte
+	var tests = [][]string{
+		{"-layout=base64"},
+		{"-g"},
+		{"-layout=blob"},
+		{"-lazy"},
+		{"-split=size", "-maxsize=100000"},
+		{"-format=bin"},
+		{"-format=bin", "-g"},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	icon = make([]byte, 20000)
+	r.Read(icon)
+	other = make([]byte, 2000)
+	r.Read(other)
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	files := map[string]string{
+		"dark/icon.png":  string(icon),
+		"light/icon.png": string(icon),
+		"icon.png":       string(icon),
+		"other.png":      string(other),
+	}
+	writeTree(t, data, files)
+
+	for _, args := range tests {
+		var sizes [2]int64
+		var out string
+		old, _ := filepath.Glob(filepath.Join(dir, "bundle*"))
+		for _, fn := range old {
+			os.Remove(fn)
+		}
+		for i, dedup := range []string{"-dedup=false", "-dedup"} {
+			out = filepath.Join(dir, "bundle.go")
+			if args[0] == "-format=bin" {
+				out = filepath.Join(dir, "bundle.bin")
+			}
+			b, err := exec.Command("mkbundle/mkbundle",
+				append(append([]string{"-a", dedup,
+					"-o", out}, args...), data)...).
+				CombinedOutput()
+			if err != nil {
+				t.Fatalf("mkbundle %v: %s\n%s", args, err, b)
+			}
+			// Main output file, and part files (if any)
+			l, _ := filepath.Glob(filepath.Join(dir, "bundle*"))
+			for _, fn := range l {
+				fi, err := os.Stat(fn)
+				if err != nil {
+					t.Fatalf("Stat(): %s", err)
+				}
+				sizes[i] += fi.Size()
+			}
+		}
+		if sizes[1] >= sizes[0]-int64(len(icon)) {
+			t.Fatalf("%v: Size %d with -dedup, %d without",
+				args, sizes[1], sizes[0])
+		}
+		for nm, exp := range files {
+			var d []byte
+			var err error
+			if args[0] == "-format=bin" {
+				var idx bundle.Index
+				idx, err = bundle.LoadFile(out)
+				if err != nil {
+					t.Fatalf("LoadFile(): %s", err)
+				}
+				d, err = idx.Entry(nm).Decode(0)
+			} else {
+				d, err = exec.Command("mkbundle/mkbundle",
+					"cat", out, nm).Output()
+			}
+			if err != nil || string(d) != exp {
+				t.Fatalf("%v: Bad data for %s: %v", args, nm, err)
+			}
+		}
+	}
+}
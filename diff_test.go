@@ -0,0 +1,100 @@
+package bundle_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestDiff checks the report of mkbundle diff, comparing bundles with
+// bundles, and directories with bundles.
+func TestDiff(t *testing.T) {
+	var afiles = map[string]string{
+		"bin.dat":  "\x00\x01",
+		"del.txt":  "x\n",
+		"mod.txt":  "one\ntwo\nthree\n",
+		"same.txt": "same",
+	}
+	var bfiles = map[string]string{
+		"add.txt":  "new\n",
+		"bin.dat":  "\x00\x02\x03",
+		"mod.txt":  "one\n2\nthree\nfour\n",
+		"same.txt": "same",
+	}
+	const exp = "M bin.dat 2 -> 3 (+1)\n" +
+		"D del.txt (-2)\n" +
+		"M mod.txt 14 -> 17 (+3)\n" +
+		"--- a/mod.txt\n+++ b/mod.txt\n" +
+		"@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n" +
+		"A add.txt (+4)\n"
+
+	dir := t.TempDir()
+	for nm, files := range map[string]map[string]string{
+		"a": afiles, "b": bfiles} {
+		writeTree(t, filepath.Join(dir, nm), files)
+		out, err := exec.Command("mkbundle/mkbundle", "-a",
+			"-o", filepath.Join(dir, nm+".go"),
+			filepath.Join(dir, nm)).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mkbundle %s: %s\n%s", nm, err, out)
+		}
+	}
+	for _, args := range [][]string{
+		{"a.go", "b.go"}, {"a", "b.go"}, {"a.go", "b"}, {"a", "b"}} {
+		out, err := exec.Command("mkbundle/mkbundle", "diff",
+			filepath.Join(dir, args[0]),
+			filepath.Join(dir, args[1])).Output()
+		if err != nil {
+			t.Fatalf("diff %v: %s", args, err)
+		}
+		if string(out) != exp {
+			t.Fatalf("diff %v:\n%s\nExpected:\n%s", args, out, exp)
+		}
+	}
+	out, err := exec.Command("mkbundle/mkbundle", "diff",
+		filepath.Join(dir, "b.go"),
+		filepath.Join(dir, "b")).Output()
+	if err != nil || len(out) != 0 {
+		t.Fatalf("diff of identical bundles: %q %v", out, err)
+	}
+}
+
+// TestTextconv checks the output of mkbundle textconv: A header line
+// for every entry, followed by the contents of text entries.
+func TestTextconv(t *testing.T) {
+	var files = map[string]string{
+		"a.txt":    "one\ntwo\n",
+		"b.dat":    "\x00\xff",
+		"c/d.json": "{}",
+	}
+	hdr := func(nm string) string {
+		return fmt.Sprintf("=== %s (%d bytes, sha256 %x)\n",
+			nm, len(files[nm]), sha256.Sum256([]byte(files[nm])))
+	}
+	exp := hdr("a.txt") + "one\ntwo\n" +
+		hdr("b.dat") +
+		hdr("c/d.json") + "{}\n"
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	writeTree(t, data, files)
+	fn := filepath.Join(dir, "bundle.go")
+	out, err := exec.Command("mkbundle/mkbundle", "-a", "-gzip",
+		"-o", fn, data).CombinedOutput()
+	if err != nil {
+		t.Fatalf("mkbundle: %s\n%s", err, out)
+	}
+	for _, arg := range []string{fn, data} {
+		out, err = exec.Command("mkbundle/mkbundle", "textconv",
+			arg).Output()
+		if err != nil {
+			t.Fatalf("textconv %s: %s", arg, err)
+		}
+		if string(out) != exp {
+			t.Fatalf("textconv %s:\n%s\nExpected:\n%s",
+				arg, out, exp)
+		}
+	}
+}
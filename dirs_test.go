@@ -0,0 +1,177 @@
+package bundle_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/npat-efault/bundle"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Stats the entries named in its arguments
+var dirs_prog = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	for _, nm := range os.Args[1:] {
+		fi, err := _bundleIdx.Stat(nm)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%q %s %d\n", nm, fi.Mode(), fi.Size())
+	}
+}
+`
+
+// TestDirEntries checks directory entries, recorded by mkbundle -dirs
+func TestDirEntries(t *testing.T) {
+	var idx bundle.Index
+	var out []byte
+	var err error
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	for _, d := range []string{"cache", "uploads/tmp", "static"} {
+		err = os.MkdirAll(filepath.Join(data, d), 0755)
+		if err != nil {
+			t.Fatalf("MkdirAll(): %s", err)
+		}
+	}
+	err = os.Chmod(filepath.Join(data, "cache"), 0700)
+	if err != nil {
+		t.Fatalf("Chmod(): %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(data, "static", "f.txt"),
+		[]byte("Test\n"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile(): %s", err)
+	}
+	for _, format := range []string{"go", "bin"} {
+		fn := filepath.Join(dir, "bundle."+format)
+		out, err = exec.Command("mkbundle/mkbundle", "-a", "-dirs",
+			"-format="+format, "-o", fn, data).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mkbundle -dirs: %s\n%s", err, out)
+		}
+	}
+	idx, err = bundle.LoadFile(filepath.Join(dir, "bundle.bin"))
+	if err != nil {
+		t.Fatalf("LoadFile(): %s", err)
+	}
+
+	var tests = []struct {
+		name string
+		mode fs.FileMode
+		size int64
+	}{
+		{"cache", fs.ModeDir | 0700, 0},
+		{"uploads", fs.ModeDir | 0755, 0},
+		{"uploads/tmp", fs.ModeDir | 0755, 0},
+		{"static", fs.ModeDir | 0755, 0},
+		{"static/f.txt", 0444, 5},
+		{"", fs.ModeDir | 0555, 0},
+		{"cache/", fs.ModeDir | 0700, 0},
+		{"uploads/tmp/", fs.ModeDir | 0755, 0},
+	}
+	for _, tst := range tests {
+		fi, err := idx.Stat(tst.name)
+		if err != nil {
+			t.Fatalf("Stat(%s): %s", tst.name, err)
+		}
+		if fi.Mode() != tst.mode || fi.Size() != tst.size ||
+			fi.IsDir() != tst.mode.IsDir() {
+			t.Fatalf("Stat(%s): %s %d, expected %s %d", tst.name,
+				fi.Mode(), fi.Size(), tst.mode, tst.size)
+		}
+		if e := idx.Entry(tst.name); e != nil &&
+			e.IsDir() != tst.mode.IsDir() {
+			t.Fatalf("%s: IsDir() = %v", tst.name, e.IsDir())
+		}
+	}
+	_, err = idx.Stat("missing")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat(missing): %v", err)
+	}
+	if _, err = idx.Stat("static/f.txt/"); err == nil {
+		t.Fatalf("Stat(static/f.txt/) succeeded")
+	}
+	// Directory entries have no data, and are not listed by Dir
+	if l := idx.Dir(""); len(l) != 1 || l[0].Name != "static/f.txt" {
+		t.Fatalf("Dir(): %v", l)
+	}
+	if _, err = idx["cache"].Decode(0); err == nil {
+		t.Fatalf("Decode of directory entry succeeded")
+	}
+	if _, err = idx.Open("uploads"); err == nil {
+		t.Fatalf("Open of directory entry succeeded")
+	}
+	l, err := fs.ReadDir(idx.FS(), "uploads")
+	if err != nil || len(l) != 1 || l[0].Name() != "tmp" || !l[0].IsDir() {
+		t.Fatalf("ReadDir(uploads): %v %v", l, err)
+	}
+
+	// Without directory entries, directories are implied
+	idx = bundle.MkIndex([]bundle.Entry{
+		{Name: "static/f.txt", Size: 5},
+	})
+	for _, nm := range []string{"static", "static/"} {
+		fi, err := idx.Stat(nm)
+		if err != nil || fi.Mode() != fs.ModeDir|0555 {
+			t.Fatalf("Stat(%s): %v %v", nm, fi, err)
+		}
+	}
+	if _, err = idx.Stat("cache"); err == nil {
+		t.Fatalf("Stat(cache) succeeded")
+	}
+
+	// The directory entries of the generated Go source, extracted
+	fn := filepath.Join(dir, "bundle.go")
+	xdir := filepath.Join(dir, "out")
+	out, err = exec.Command("mkbundle/mkbundle", "extract",
+		fn, xdir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("extract: %s\n%s", err, out)
+	}
+	for _, tst := range tests {
+		fi, err := os.Stat(filepath.Join(xdir, tst.name))
+		if err != nil {
+			t.Fatalf("extract: %s", err)
+		}
+		if fi.IsDir() != tst.mode.IsDir() || fi.IsDir() &&
+			tst.name != "" && fi.Mode().Perm() != tst.mode.Perm() {
+			t.Fatalf("extract: %s: %s, expected %s",
+				tst.name, fi.Mode(), tst.mode)
+		}
+	}
+
+	// ... and compiled in a program
+	var exp []string
+	var names []string
+	for _, tst := range tests {
+		names = append(names, tst.name)
+		exp = append(exp, fmt.Sprintf("%q %s %d",
+			tst.name, tst.mode, tst.size))
+	}
+	bsrc, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("ReadFile(): %s", err)
+	}
+	prog, _ := buildProg(t, []byte(dirs_prog), bsrc)
+	out, err = exec.Command(prog, names...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("prog: %s\n%s", err, out)
+	}
+	if s := strings.Join(exp, "\n") + "\n"; string(out) != s {
+		t.Fatalf("Bad prog output:\n%s\nExpected:\n%s", out, s)
+	}
+}
@@ -31,24 +31,28 @@ be generated and it will contain the following Go code:
   import "github.com/npat-efault/bundle"
 
   var _bundle = []bundle.Entry{
-  { Name : "file1.txt",
-    Size : 21,
-    Gzip : false,
-    Data : `
+  	{
+  		Name: "file1.txt",
+  		Size: 21,
+  		Gzip: false,
+  		Data: `
   VGVzdCBmaWxlIDEgY29udGVudHMK
-  `},
-  { Name : "file2.txt",
-    Size : 21,
-    Gzip : false,
-    Data : `
+  `,
+  	},
+  	{
+  		Name: "file2.txt",
+  		Size: 21,
+  		Gzip: false,
+  		Data: `
   VGVzdCBmaWxlIDIgY29udGVudHMK
-  `},
+  `,
+  	},
   }
 
   var _bundleIdx bundle.Index
 
   func init() {
-        _bundleIdx = bundle.MkIndex(_bundle)
+  	_bundleIdx = bundle.MkIndex(_bundle)
   }
 
   // End of bundle
@@ -73,10 +77,38 @@ file "tstbundle.go" :
       }
   }
 
-The Dir() method, defined on _bundleIdx, returns a slice of
-pointers to the entries in the index with names matching the given
-prefix (all entries for an empty prefix) sorted by name in ascending
-order.
+The Dir() method, defined on _bundleIdx, returns a slice of pointers
+to the file entries in the index with names matching the given prefix
+(all file entries for an empty prefix) sorted by name in ascending
+order. The Sub() method returns a view of the index containing only
+the entries under a given directory, with names relative to that
+directory. This way parts of a bundle can be handed to code that does
+not need to know where they are located in the bundle. The Glob()
+method returns the entries with names matching a glob pattern, where a
+"**" path element matches any number of directories.
+
+Bundles can also hold link entries, recorded by "mkbundle
+-symlinks=link" for symbolic links. Link entries are resolved to
+their targets by the Entry, Has, and Open methods of the index
+(including links to directories, in the middle of entry names). Link
+entries have no data, and are not listed by Dir and Glob (see field
+Entry.Link).
+
+Similarly, bundles can hold directory entries ("mkbundle -dirs"),
+which record the modes of directories, and preserve empty ones. The
+Stat method of the index reports entries and directories (whether
+there are directory entries for them, or not) as fs.FileInfo values.
+
+The FS() method returns the index as an fs.FS, which can be used
+with net/http, html/template, fs.WalkDir, etc. The file system lists
+directories (with their link and directory entries), resolves links
+when opening or reading files, and reports links as symbolic links
+when listing them.
+
+Programs that access the same entries very often can use a Cache
+(see NewCache) which keeps the decoded data of recently used entries,
+up to a given number of bytes, so that they are not decoded again on
+every access.
 
 The code above, compiled and linked together with the generated file
 "mybundle.go", when run produces the output:
@@ -92,6 +124,14 @@ in our example), the name of the global name-to-entry index
 options can be controlled by flags passed to the "mkbundle"
 command. See the command's documentation for more information.
 
+Bundles can also be stored in binary container files, generated by
+"mkbundle -format=bin", and loaded at runtime using the Load or
+LoadFile functions, which return an Index just like the one
+generated for bundles compiled in the program. This way bundled data
+can be updated without rebuilding the program. Containers can also
+be appended to an already built executable ("mkbundle -append"), and
+accessed by the program using the OpenSelf function.
+
 Summarizing: The command "mkbundle" allows arbitrary data files to be
 embedded in Go binaries by converting the files to statements
 initializing global variables. This module
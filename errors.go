@@ -0,0 +1,76 @@
+// Error values returned by the bundle interface
+
+package bundle
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// ErrCorrupt is the error wrapped by the errors returned when the
+// data of an entry cannot be decoded or decompressed. Such errors
+// are of type *fs.PathError, with Path set to the name of the
+// entry. They also wrap the underlying (gzip, base64, etc.) error,
+// so callers can do:
+//
+//	if errors.Is(err, bundle.ErrCorrupt) { ... }
+//
+// Errors returned for entries missing from an index are also of type
+// *fs.PathError, and wrap fs.ErrNotExist.
+var ErrCorrupt = errors.New("corrupt entry data")
+
+// ErrTooLarge is the error wrapped by the errors returned when the
+// decoded data of an entry are larger than the entry size, or larger
+// than MaxSize. Such errors are also of type *fs.PathError.
+var ErrTooLarge = errors.New("entry data too large")
+
+// ErrFormat is the error wrapped by the errors returned when a
+// bundle container (see Load) is malformed.
+var ErrFormat = errors.New("malformed bundle container")
+
+// errChecksum is the error wrapped (together with ErrCorrupt) by the
+// errors returned when the checksum of the decoded entry data does
+// not match the checksum recorded in the entry.
+var errChecksum = errors.New("checksum mismatch")
+
+// Errors wrapped by the errors returned when an operation is not
+// possible for the type of an entry.
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+	errIsLink = errors.New("is a link")
+)
+
+// errCorrupt returns the error reported when operation "op" fails
+// because the data of entry "name" are corrupt. Argument "err" is
+// the underlying error.
+func errCorrupt(op, name string, err error) error {
+	return &fs.PathError{
+		Op:   op,
+		Path: name,
+		Err:  fmt.Errorf("%w: %w", ErrCorrupt, err),
+	}
+}
+
+// errNotExist returns the error reported when operation "op" fails
+// because there is no entry named "name" in the index.
+func errNotExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// errTooLarge returns the error reported when operation "op" fails
+// because the data of entry "name" are too large.
+func errTooLarge(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: ErrTooLarge}
+}
+
+// errNotRegular returns the error reported when operation "op" fails
+// because entry "e" is a link or a directory entry, which have no
+// data.
+func errNotRegular(op string, e *Entry) error {
+	if e.IsDir() {
+		return &fs.PathError{Op: op, Path: e.Name, Err: errIsDir}
+	}
+	return &fs.PathError{Op: op, Path: e.Name, Err: errIsLink}
+}
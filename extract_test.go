@@ -0,0 +1,116 @@
+package bundle_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestExtract checks that mkbundle extract writes back the files a
+// bundle was generated from, for all bundle layouts, and that "ls"
+// and "cat" see the same entries.
+func TestExtract(t *testing.T) {
+	var tests = [][]string{
+		{},
+		{"-gzip"},
+		{"-layout=blob"},
+		{"-stable", "-split=dir"},
+		{"-split=size", "-maxsize=200000"},
+		{"-solid=100000"},
+	}
+
+	entries, err := mkentries(data_dir)
+	if err != nil {
+		t.Fatalf("mkentries failed (%s): %s", data_dir, err)
+	}
+	for i, args := range tests {
+		dir := t.TempDir()
+		fn := filepath.Join(dir, "bundle.go")
+		out := filepath.Join(dir, "out")
+		b, err := exec.Command("mkbundle/mkbundle",
+			append(append([]string{"-a", "-o", fn}, args...),
+				data_dir)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mkbundle %v: %s\n%s", args, err, b)
+		}
+		b, err = exec.Command("mkbundle/mkbundle", "extract",
+			fn, out).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: extract: %s\n%s", args, err, b)
+		}
+		l, err := mkentries(out)
+		if err != nil || strings.Join(l, " ") !=
+			strings.Join(entries, " ") {
+			t.Fatalf("%v: extracted %v, expected %v (%v)",
+				args, l, entries, err)
+		}
+		b, err = exec.Command("mkbundle/mkbundle", "ls",
+			fn).Output()
+		if err != nil {
+			t.Fatalf("%v: ls: %s", args, err)
+		}
+		if n := strings.Count(string(b), "\n"); n != len(entries) {
+			t.Fatalf("%v: ls: %d lines, expected %d",
+				args, n, len(entries))
+		}
+		for _, nm := range entries {
+			fdata, err := ioutil.ReadFile(
+				filepath.Join(data_dir, nm))
+			if err != nil {
+				t.Fatalf("ReadFile(): %s", err)
+			}
+			d, err := ioutil.ReadFile(filepath.Join(out, nm))
+			if err != nil || !bytes.Equal(d, fdata) {
+				t.Fatalf("%v: Bad extracted data for %s: %v",
+					args, nm, err)
+			}
+			if i > 0 && !strings.HasPrefix(nm, "text/") {
+				// Cat the (smaller) text files only, once
+				continue
+			}
+			d, err = exec.Command("mkbundle/mkbundle", "cat",
+				fn, filepath.ToSlash(nm)).Output()
+			if err != nil || !bytes.Equal(d, fdata) {
+				t.Fatalf("%v: Bad cat data for %s: %v",
+					args, nm, err)
+			}
+		}
+	}
+}
+
+// TestExtractNames checks that mkbundle extract refuses bundles with
+// entry names that are not local to the extraction directory,
+// without writing anything.
+func TestExtractNames(t *testing.T) {
+	const src = "package data\n\n" +
+		"import \"github.com/npat-efault/bundle\"\n\n" +
+		"var _bundle = []bundle.Entry{\n" +
+		"\t{Name: \"a/f.txt\", Size: 1, Data: \"YQ==\"},\n" +
+		"\t{Name: %q, Size: 1, Data: \"YQ==\"},\n" +
+		"}\n"
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "bundle.go")
+	out := filepath.Join(dir, "out")
+	for _, nm := range []string{"../x", "a/../../x", "/tmp/x", "",
+		".."} {
+		err := ioutil.WriteFile(fn, []byte(fmt.Sprintf(src, nm)),
+			0644)
+		if err != nil {
+			t.Fatalf("WriteFile(): %s", err)
+		}
+		b, err := exec.Command("mkbundle/mkbundle", "extract",
+			fn, out).CombinedOutput()
+		if err == nil || !strings.Contains(string(b), "bad entry name") {
+			t.Fatalf("extract of %q: %v\n%s", nm, err, b)
+		}
+		if _, err = os.Lstat(out); err == nil {
+			t.Fatalf("extract of %q: %s created", nm, out)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package bundle_test
+
+import (
+	"github.com/npat-efault/bundle"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestFilters checks the build-time filters of mkbundle (flag
+// "-filter"), and that entry sizes reflect the filtered data.
+func TestFilters(t *testing.T) {
+	var files = map[string]string{
+		"a.json":     "\xef\xbb\xbf{ \"a\" : [1, 2],\r\n  \"b\": \"x y\" }\r\n",
+		"sub/b.json": "[ 1,\n 2 ]\n",
+		"c.txt":      "one  \r\ntwo\t\nthree\n",
+		"d.txt":      "one  \r\n",
+	}
+	var tests = []struct {
+		args []string
+		exp  map[string]string
+	}{
+		{nil, files},
+		{[]string{"-filter=*.json=bom", "-filter=*.json=json",
+			"-filter=*.txt=trim", "-filter=/c.txt=crlf"},
+			map[string]string{
+				"a.json":     `{"a":[1,2],"b":"x y"}`,
+				"sub/b.json": "[1,2]",
+				"c.txt":      "one\ntwo\nthree\n",
+				"d.txt":      "one\r\n",
+			}},
+		{[]string{"-filter=sub/*.json=json", "-filter=*.txt=crlf"},
+			map[string]string{
+				"a.json":     files["a.json"],
+				"sub/b.json": "[1,2]",
+				"c.txt":      "one  \ntwo\t\nthree\n",
+				"d.txt":      "one  \n",
+			}},
+	}
+	if runtime.GOOS != "windows" {
+		tests = append(tests, struct {
+			args []string
+			exp  map[string]string
+		}{[]string{"-filter=d.txt=|tr a-z A-Z"},
+			map[string]string{
+				"a.json":     files["a.json"],
+				"sub/b.json": files["sub/b.json"],
+				"c.txt":      files["c.txt"],
+				"d.txt":      "ONE  \r\n",
+			}})
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	writeTree(t, data, files)
+	out := filepath.Join(dir, "bundle.bin")
+	for _, tst := range tests {
+		args := append([]string{"-a", "-format=bin", "-o", out},
+			tst.args...)
+		b, err := exec.Command("mkbundle/mkbundle",
+			append(args, data)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mkbundle %v: %s\n%s", tst.args, err, b)
+		}
+		idx, err := bundle.LoadFile(out)
+		if err != nil {
+			t.Fatalf("LoadFile(): %s", err)
+		}
+		for nm, exp := range tst.exp {
+			e := idx.Entry(nm)
+			if e == nil {
+				t.Fatalf("%v: Entry(%s) not found", tst.args, nm)
+			}
+			d, err := e.Decode(0)
+			if err != nil || string(d) != exp || e.Size != len(exp) {
+				t.Fatalf("%v: Bad data for %s: %q (size %d) %v",
+					tst.args, nm, d, e.Size, err)
+			}
+		}
+	}
+
+	// Bad filters
+	for _, f := range []string{"-filter=*.json", "-filter=*.json=x",
+		"-filter=*.txt=|", "-filter=*.txt=json"} {
+		b, err := exec.Command("mkbundle/mkbundle", "-a",
+			"-format=bin", "-o", out, f, data).CombinedOutput()
+		if err == nil {
+			t.Fatalf("mkbundle %s: no error\n%s", f, b)
+		}
+	}
+}
+
+// TestExpand checks the expansion of ${NAME} placeholders by the
+// "expand" filter of mkbundle, with variables given by "-D" flags,
+// the manifest file, and the environment.
+func TestExpand(t *testing.T) {
+	var tests = []struct {
+		args []string
+		exp  string // Empty if mkbundle should fail
+	}{
+		{[]string{"-D", "VERSION=1.2", "-D", "BUILD=7"},
+			"v1.2 (7) ${VERSION} ${x-y} $VERSION by me\n"},
+		{[]string{"-D", "VERSION=1.2"}, ""},
+		{[]string{"-D", "VERSION=1.2", "-allowundef"},
+			"v1.2 (${BUILD}) ${VERSION} ${x-y} $VERSION by me\n"},
+		{[]string{"-manifest", "manifest.txt"},
+			"v0.9 (42) ${VERSION} ${x-y} $VERSION by me\n"},
+		{[]string{"-manifest", "manifest.txt", "-D", "BUILD=8"},
+			"v0.9 (8) ${VERSION} ${x-y} $VERSION by me\n"},
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	err := os.MkdirAll(data, 0777)
+	if err != nil {
+		t.Fatalf("MkdirAll(): %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(data, "about.html"),
+		[]byte("v${VERSION} (${BUILD}) $${VERSION} ${x-y} "+
+			"$VERSION by ${BUNDLE_TEST_AUTHOR}\n"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile(): %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "manifest.txt"),
+		[]byte("# Variables\nVERSION = 0.9\n\nBUILD=42\n"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile(): %s", err)
+	}
+	mkb, err := filepath.Abs("mkbundle/mkbundle")
+	if err != nil {
+		t.Fatalf("Abs(): %s", err)
+	}
+	var env []string
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "VERSION=") &&
+			!strings.HasPrefix(v, "BUILD=") {
+			env = append(env, v)
+		}
+	}
+	env = append(env, "BUNDLE_TEST_AUTHOR=me")
+	out := filepath.Join(dir, "bundle.bin")
+	for _, tst := range tests {
+		args := append([]string{"-a", "-format=bin", "-o", out,
+			"-filter=*.html=expand"}, tst.args...)
+		cmd := exec.Command(mkb, append(args, data)...)
+		cmd.Dir = dir
+		cmd.Env = env
+		b, err := cmd.CombinedOutput()
+		if tst.exp == "" {
+			if err == nil {
+				t.Fatalf("mkbundle %v: no error", tst.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("mkbundle %v: %s\n%s", tst.args, err, b)
+		}
+		idx, err := bundle.LoadFile(out)
+		if err != nil {
+			t.Fatalf("LoadFile(): %s", err)
+		}
+		d, err := idx.Entry("about.html").Decode(0)
+		if err != nil || string(d) != tst.exp {
+			t.Fatalf("%v: Bad data: %q %v", tst.args, d, err)
+		}
+	}
+}
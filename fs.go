@@ -0,0 +1,237 @@
+// The io/fs interface to bundles
+
+package bundle
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The FS method returns the bundle as a (read-only) file system, for
+// use by the packages that accept an fs.FS (e.g. net/http,
+// html/template, or fs.WalkDir). Besides fs.FS, the file system
+// implements fs.StatFS, fs.ReadDirFS, fs.ReadFileFS, and
+// fs.ReadLinkFS. Link entries are resolved by Open, Stat and
+// ReadFile, like by Index.Entry, and are listed by ReadDir (and
+// reported by Lstat) as symbolic links. Directories are listed
+// whether there are directory entries for them, or not (see
+// Index.Stat). The index must not be modified while the file system
+// is in use.
+func (idx Index) FS() fs.FS {
+	return indexFS{idx}
+}
+
+// indexFS is the file system returned by Index.FS
+type indexFS struct {
+	idx Index
+}
+
+// file is an open file entry of an indexFS
+type file struct {
+	*Reader
+	fi *entryInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
+
+// dir is an open directory of an indexFS
+type dir struct {
+	name    string
+	fi      *entryInfo
+	entries []fs.DirEntry
+	off     int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.fi, nil
+}
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	l := d.entries[d.off:]
+	if n > 0 && len(l) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(l) {
+		l = l[:n]
+	}
+	d.off += len(l)
+	return l, nil
+}
+
+// The Open method opens the named file or directory. Link entries
+// are resolved.
+func (fsys indexFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name,
+			Err: fs.ErrInvalid}
+	}
+	e, fi, err := fsys.idx.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		br, err := e.Open(0)
+		if err != nil {
+			return nil, err
+		}
+		return &file{Reader: br, fi: fi}, nil
+	}
+	r, _ := fsys.idx.resolveName(name)
+	return &dir{name: name, fi: fi, entries: fsys.readDir(r)}, nil
+}
+
+// The Stat method returns an fs.FileInfo describing the named file or
+// directory, like Index.Stat.
+func (fsys indexFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name,
+			Err: fs.ErrInvalid}
+	}
+	return fsys.idx.Stat(name)
+}
+
+// The ReadDir method returns the entries of the named directory,
+// sorted by name.
+func (fsys indexFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name,
+			Err: fs.ErrInvalid}
+	}
+	_, fi, err := fsys.idx.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name,
+			Err: errNotDir}
+	}
+	r, _ := fsys.idx.resolveName(name)
+	return fsys.readDir(r), nil
+}
+
+// The ReadFile method returns the (decoded) data of the named file.
+func (fsys indexFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name,
+			Err: fs.ErrInvalid}
+	}
+	e, fi, err := fsys.idx.stat("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name,
+			Err: errIsDir}
+	}
+	return e.Decode(0)
+}
+
+// The ReadLink method returns the target of the named link entry.
+func (fsys indexFS) ReadLink(name string) (string, error) {
+	e, err := fsys.link("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if e == nil {
+		return "", &fs.PathError{Op: "readlink", Path: name,
+			Err: fs.ErrInvalid}
+	}
+	return e.Link, nil
+}
+
+// The Lstat method is like Stat, but if the named entry is a link
+// entry, it describes the link itself.
+func (fsys indexFS) Lstat(name string) (fs.FileInfo, error) {
+	e, err := fsys.link("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return fsys.idx.Stat(name)
+	}
+	return linkInfo(path.Base(name), e), nil
+}
+
+// link returns the link entry with the given name, or nil if the
+// named entry is not a link. Links in the directories of the name
+// are resolved, but the entry itself is not.
+func (fsys indexFS) link(op, name string) (*Entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name,
+			Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil, nil
+	}
+	d, ok := fsys.idx.resolveName(path.Dir(name))
+	if !ok {
+		return nil, errNotExist(op, name)
+	}
+	e := fsys.idx[path.Join(d, path.Base(name))]
+	if e == nil || e.Link == "" {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// linkInfo returns an fs.FileInfo describing link entry "e", named
+// "name".
+func linkInfo(name string, e *Entry) *entryInfo {
+	return &entryInfo{name: name, size: int64(len(e.Link)),
+		mode: fs.ModeSymlink | 0777}
+}
+
+// readDir returns the entries of directory "name", which must be
+// resolved (contain no links), sorted by name.
+func (fsys indexFS) readDir(name string) []fs.DirEntry {
+	var names []string
+	var l []fs.DirEntry
+	var prefix string
+
+	if name != "." && name != "" {
+		prefix = name + "/"
+	}
+	seen := make(map[string]bool)
+	for nm := range fsys.idx {
+		if !strings.HasPrefix(nm, prefix) {
+			continue
+		}
+		c := nm[len(prefix):]
+		if i := strings.IndexByte(c, '/'); i >= 0 {
+			c = c[:i]
+		}
+		if c == "" || c == "." || c == ".." || seen[c] {
+			continue
+		}
+		seen[c] = true
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	for _, c := range names {
+		var fi *entryInfo
+
+		if e := fsys.idx[prefix+c]; e != nil && e.Link != "" {
+			fi = linkInfo(c, e)
+		} else {
+			_, fi, _ = fsys.idx.stat("readdir", prefix+c)
+		}
+		if fi == nil {
+			continue
+		}
+		l = append(l, fs.FileInfoToDirEntry(fi))
+	}
+	return l
+}
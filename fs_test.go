@@ -0,0 +1,98 @@
+package bundle_test
+
+import (
+	"errors"
+	"github.com/npat-efault/bundle"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	var entries []string
+	var err error
+
+	entries, err = mkentries(data_dir)
+	if err != nil {
+		t.Fatalf("mkentries failed (%s): %s", data_dir, err)
+	}
+	err = fstest.TestFS(_bundleIdx.FS(), entries...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fstest.TestFS(_bundleIdx.Sub("text").FS(), "readme.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSLinks(t *testing.T) {
+	var idx bundle.Index
+	var err error
+
+	idx = bundle.MkIndex([]bundle.Entry{
+		{Name: "a/f.txt", Size: 1, Data: "YQ=="},
+		{Name: "a/lf", Link: "f.txt"},
+		{Name: "b", Link: "a"},
+		{Name: "empty", Mode: fs.ModeDir | 0700},
+	})
+	fsys := idx.FS()
+	err = fstest.TestFS(fsys, "a/f.txt", "a/lf", "b", "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nm := range []string{"a/f.txt", "a/lf", "b/lf"} {
+		data, err := fs.ReadFile(fsys, nm)
+		if err != nil || string(data) != "a" {
+			t.Fatalf("ReadFile(%s): %q %v", nm, data, err)
+		}
+	}
+	l, err := fs.ReadDir(fsys, ".")
+	if err != nil || len(l) != 3 || l[1].Name() != "b" ||
+		l[1].Type() != fs.ModeSymlink || !l[2].IsDir() {
+		t.Fatalf("ReadDir(.): %v %v", l, err)
+	}
+	l, err = fs.ReadDir(fsys, "b")
+	if err != nil || len(l) != 2 || l[0].Name() != "f.txt" {
+		t.Fatalf("ReadDir(b): %v %v", l, err)
+	}
+	if fi, err := fs.Stat(fsys, "b"); err != nil || !fi.IsDir() {
+		t.Fatalf("Stat(b): %v %v", fi, err)
+	}
+	if fi, err := fs.Lstat(fsys, "b/lf"); err != nil ||
+		fi.Mode().Type() != fs.ModeSymlink {
+		t.Fatalf("Lstat(b/lf): %v %v", fi, err)
+	}
+	if s, err := fs.ReadLink(fsys, "b/lf"); err != nil || s != "f.txt" {
+		t.Fatalf("ReadLink(b/lf): %q %v", s, err)
+	}
+
+	// Link entries have no data, and are not listed by Dir
+	if l := idx.Dir(""); len(l) != 1 || l[0].Name != "a/f.txt" {
+		t.Fatalf("Dir(): %v", l)
+	}
+	if _, err = idx["a/lf"].Decode(0); err == nil {
+		t.Fatalf("Decode of link entry succeeded")
+	}
+
+	// Errors
+	for _, nm := range []string{"missing", "a/missing", "b/missing"} {
+		if _, err = fsys.Open(nm); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("Open(%s): %v", nm, err)
+		}
+	}
+	for _, nm := range []string{"", "/a", "a/", "./a", "a/../b"} {
+		if _, err = fsys.Open(nm); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("Open(%q): %v", nm, err)
+		}
+	}
+	if _, err = fs.ReadFile(fsys, "b"); err == nil {
+		t.Fatalf("ReadFile of directory succeeded")
+	}
+	if _, err = fs.ReadDir(fsys, "a/f.txt"); err == nil {
+		t.Fatalf("ReadDir of file succeeded")
+	}
+	if _, err = fs.ReadLink(fsys, "a/f.txt"); err == nil {
+		t.Fatalf("ReadLink of file succeeded")
+	}
+}
@@ -0,0 +1,85 @@
+// Glob-pattern matching of entry names
+
+package bundle
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Match reports whether "name" matches the glob "pattern". The
+// pattern syntax is that of path.Match, with one addition: A path
+// element consisting only of "**" matches zero or more path elements
+// (directories). For example, pattern "locales/**/*.json" matches
+// "locales/en.json" as well as "locales/en/US/msg.json". Names and
+// patterns are slash-separated. The only possible returned error is
+// path.ErrBadPattern, when the pattern is malformed.
+func Match(pattern, name string) (bool, error) {
+	var pe []string
+
+	pe = strings.Split(pattern, "/")
+	for _, p := range pe {
+		if p == "**" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return false, err
+		}
+	}
+	return matchElems(pe, strings.Split(name, "/")), nil
+}
+
+// matchElems matches the name elements "ne" against the (validated)
+// pattern elements "pe".
+func matchElems(pe, ne []string) bool {
+	for len(pe) > 0 {
+		if pe[0] == "**" {
+			// Collapse consecutive "**" elements
+			for len(pe) > 0 && pe[0] == "**" {
+				pe = pe[1:]
+			}
+			if len(pe) == 0 {
+				return true
+			}
+			for i := 0; i <= len(ne); i++ {
+				if matchElems(pe, ne[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(ne) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pe[0], ne[0]); !ok {
+			return false
+		}
+		pe, ne = pe[1:], ne[1:]
+	}
+	return len(ne) == 0
+}
+
+// The Glob method returns a Dir (slice of Entry pointers) of all the
+// file entries whose names match the given pattern, sorted by name.
+// Like Dir, it does not return link and directory entries. See
+// function Match for the pattern syntax. Returns an error if the
+// pattern is malformed.
+func (idx Index) Glob(pattern string) ([]*Entry, error) {
+	var dir Dir
+
+	// Check pattern, even if the index is empty
+	if _, err := Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	for _, e := range idx {
+		if e.Link != "" || e.IsDir() {
+			continue
+		}
+		if ok, _ := Match(pattern, e.Name); ok {
+			dir = append(dir, e)
+		}
+	}
+	sort.Sort(dir)
+	return dir, nil
+}
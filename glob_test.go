@@ -0,0 +1,69 @@
+package bundle_test
+
+import (
+	"github.com/npat-efault/bundle"
+	"path"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	var tests = []struct {
+		pat, name string
+		ok        bool
+	}{
+		{"*.txt", "a.txt", true},
+		{"*.txt", "d/a.txt", false},
+		{"d/*.txt", "d/a.txt", true},
+		{"**/*.txt", "a.txt", true},
+		{"**/*.txt", "d/e/a.txt", true},
+		{"d/**/*.json", "d/a.json", true},
+		{"d/**/*.json", "d/e/f/a.json", true},
+		{"d/**/*.json", "x/d/a.json", false},
+		{"d/**", "d/e/f", true},
+		{"d/**/f", "d/e/x", false},
+		{"**", "a/b/c", true},
+		{"a/**/**/b", "a/b", true},
+		{"a?c/[xy]", "abc/y", true},
+		{"a?c/[xy]", "abc/z", false},
+	}
+	for _, tst := range tests {
+		ok, err := bundle.Match(tst.pat, tst.name)
+		if err != nil {
+			t.Fatalf("Match(%q, %q): %s", tst.pat, tst.name, err)
+		}
+		if ok != tst.ok {
+			t.Fatalf("Match(%q, %q) = %v != %v",
+				tst.pat, tst.name, ok, tst.ok)
+		}
+	}
+	_, err := bundle.Match("a/[x", "a/b")
+	if err != path.ErrBadPattern {
+		t.Fatalf("Match with bad pattern: %v", err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	var d []*bundle.Entry
+	var err error
+
+	d, err = _bundleIdx.Glob("text/locales/**/*.json")
+	if err != nil {
+		t.Fatalf("Glob(): %s", err)
+	}
+	if len(d) != 2 ||
+		d[0].Name != "text/locales/el/messages.json" ||
+		d[1].Name != "text/locales/en/messages.json" {
+		t.Fatalf("Bad Glob result: %v", d)
+	}
+	d, err = _bundleIdx.Glob("*.jp*g")
+	if err != nil {
+		t.Fatalf("Glob(): %s", err)
+	}
+	if len(d) != 3 {
+		t.Fatalf("Glob returned %d != 3 entries", len(d))
+	}
+	_, err = _bundleIdx.Glob("text/[")
+	if err != path.ErrBadPattern {
+		t.Fatalf("Glob with bad pattern: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package bundle_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Uses a single (small) entry, through its accessor
+var lazy_one_prog = `package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+)
+
+func main() {
+	e := AssetTextReadmeTXT()
+	data, err := e.Decode(0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s %d %x\n", e.Name, e.Size, sha256.Sum256(data))
+}
+`
+
+// Uses all entries, through the index
+var lazy_all_prog = `package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+)
+
+func main() {
+	for _, e := range _bundleIdx().Dir("") {
+		data, err := e.Decode(0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s %d %x\n", e.Name, e.Size, sha256.Sum256(data))
+	}
+}
+`
+
+// Uses a link entry, through its accessor
+var lazy_link_prog = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	e := AssetLf()
+	data, err := e.Decode(0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s %q\n", e.Name, data)
+}
+`
+
+func TestLazySize(t *testing.T) {
+	var bsrc []byte
+	var err error
+
+	bsrc, err = exec.Command("mkbundle/mkbundle", "-lazy", "-names=func",
+		data_dir).Output()
+	if err != nil {
+		t.Fatalf("mkbundle -lazy: %s", err)
+	}
+	one, _ := buildProg(t, []byte(lazy_one_prog), bsrc)
+	all, _ := buildProg(t, []byte(lazy_all_prog), bsrc)
+	fdata, err := ioutil.ReadFile(data_dir + "text/readme.txt")
+	if err != nil {
+		t.Fatalf("ReadFile(): %s", err)
+	}
+	runProg(t, one, fmt.Sprintf("text/readme.txt %d %x",
+		len(fdata), sha256.Sum256(fdata)))
+	runProg(t, all, progOutput(t, data_dir))
+
+	fone, err := os.Stat(one)
+	if err != nil {
+		t.Fatalf("Stat(): %s", err)
+	}
+	fall, err := os.Stat(all)
+	if err != nil {
+		t.Fatalf("Stat(): %s", err)
+	}
+	t.Logf("Binary size: one entry %d, all entries %d",
+		fone.Size(), fall.Size())
+	// The images alone are more than 470KB
+	if fall.Size()-fone.Size() < 470000 {
+		t.Fatalf("Unused entries not dropped: %d vs %d bytes",
+			fone.Size(), fall.Size())
+	}
+}
+
+// TestLazyLinks checks that the accessors of link entries, with
+// -lazy, return the targets of the links
+func TestLazyLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test does not run on Windows")
+	}
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"f.txt": "Test\n"})
+	err := os.Symlink("f.txt", filepath.Join(dir, "lf"))
+	if err != nil {
+		t.Fatalf("Symlink(): %s", err)
+	}
+	bsrc, err := exec.Command("mkbundle/mkbundle", "-lazy",
+		"-names=func", "-symlinks=link", dir).Output()
+	if err != nil {
+		t.Fatalf("mkbundle -lazy: %s", err)
+	}
+	prog, _ := buildProg(t, []byte(lazy_link_prog), bsrc)
+	runProg(t, prog, `f.txt "Test\n"`)
+}
@@ -0,0 +1,187 @@
+package bundle_test
+
+import (
+	"fmt"
+	"github.com/npat-efault/bundle"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLinkEntries(t *testing.T) {
+	var idx bundle.Index
+
+	idx = bundle.MkIndex([]bundle.Entry{
+		{Name: "a/f.txt", Size: 1, Data: "YQ=="},
+		{Name: "a/lf", Link: "f.txt"},
+		{Name: "a/ll", Link: "lf"},
+		{Name: "b", Link: "a"},
+		{Name: "up", Link: "../x"},
+		{Name: "loop1", Link: "loop2"},
+		{Name: "loop2", Link: "loop1"},
+		{Name: "dangling", Link: "a/none"},
+	})
+	for _, nm := range []string{"a/f.txt", "a/lf", "a/ll", "b/f.txt",
+		"b/lf", "b/../a/f.txt"} {
+		e := idx.Entry(nm)
+		if e == nil || e.Name != "a/f.txt" {
+			t.Fatalf("Entry(%s) = %v", nm, e)
+		}
+		r, err := idx.Open(nm)
+		if err != nil {
+			t.Fatalf("Open(%s): %s", nm, err)
+		}
+		r.Close()
+	}
+	for _, nm := range []string{"up", "loop1", "dangling", "b/none"} {
+		if idx.Has(nm) {
+			t.Fatalf("Has(%s) = true", nm)
+		}
+		if _, err := idx.Open(nm); err == nil {
+			t.Fatalf("Open(%s) succeeded", nm)
+		}
+	}
+}
+
+// TestSymlinks checks the handling of symbolic links by mkbundle
+func TestSymlinks(t *testing.T) {
+	var idx bundle.Index
+	var out []byte
+	var err error
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Test does not run on Windows")
+	}
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	err = os.MkdirAll(filepath.Join(data, "a"), 0777)
+	if err != nil {
+		t.Fatalf("MkdirAll(): %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(data, "a", "f.txt"),
+		[]byte("Test\n"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile(): %s", err)
+	}
+	for nm, target := range map[string]string{
+		"a/lf": "f.txt", // Link to file
+		"b":    "a",     // Link to directory
+		"a/up": "..",    // Cycle
+	} {
+		err = os.Symlink(target, filepath.Join(data, nm))
+		if err != nil {
+			t.Fatalf("Symlink(): %s", err)
+		}
+	}
+
+	var tests = []struct {
+		mode  string
+		count int // Number of entries
+		names []string
+	}{
+		{"skip", 1, []string{"a/f.txt"}},
+		{"follow", 4, []string{"a/f.txt", "a/lf", "b/f.txt", "b/lf"}},
+		// a/f.txt, and the links a/lf, a/up, and b
+		{"link", 4, []string{"a/f.txt", "a/lf", "b/f.txt", "b/lf",
+			"a/up/b/up/a/f.txt"}},
+	}
+	out_file := filepath.Join(dir, "bundle.bin")
+	for _, tst := range tests {
+		out, err = exec.Command("mkbundle/mkbundle", "-a",
+			"-format=bin", "-symlinks="+tst.mode, "-o", out_file,
+			data).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mkbundle -symlinks=%s: %s\n%s",
+				tst.mode, err, out)
+		}
+		idx, err = bundle.LoadFile(out_file)
+		if err != nil {
+			t.Fatalf("LoadFile(): %s", err)
+		}
+		if len(idx) != tst.count {
+			t.Fatalf("-symlinks=%s: %d entries, expected %d",
+				tst.mode, len(idx), tst.count)
+		}
+		for _, nm := range tst.names {
+			e := idx.Entry(nm)
+			if e == nil {
+				t.Fatalf("-symlinks=%s: Entry(%s) not found",
+					tst.mode, nm)
+			}
+			d, err := e.Decode(0)
+			if err != nil || string(d) != "Test\n" {
+				t.Fatalf("-symlinks=%s: Bad data for %s: %q %v",
+					tst.mode, nm, d, err)
+			}
+		}
+	}
+	if e := idx["b"]; e == nil || e.Link != "a" {
+		t.Fatalf("Bad link entry: %+v", e)
+	}
+}
+
+// TestExtractLinks checks that mkbundle extract refuses bundles with
+// links that would point outside the extraction directory, once
+// extracted, including through other links.
+func TestExtractLinks(t *testing.T) {
+	const header = "package data\n\n" +
+		"import \"github.com/npat-efault/bundle\"\n\n" +
+		"var _bundle = []bundle.Entry{\n" +
+		"\t{Name: \"a/f.txt\", Size: 1, Data: \"YQ==\"},\n"
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Test does not run on Windows")
+	}
+	var tests = []struct {
+		links map[string]string
+		ok    bool
+	}{
+		{map[string]string{"a/lf": "f.txt", "b": "a", "a/up": "..",
+			"loop1": "loop2", "loop2": "loop1",
+			"x": "b/up/a/f.txt"}, true},
+		{map[string]string{"up": ".."}, false},
+		{map[string]string{"up": "/tmp"}, false},
+		// Link under link
+		{map[string]string{"a/b": "..", "a/b/c": "../.."}, false},
+		// Link through link
+		{map[string]string{"d/l": "..", "x": "d/l/.."}, false},
+		{map[string]string{"b": "a", "a/up": "..",
+			"x": "b/up/b/up/.."}, false},
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bundle.go")
+	for i, tst := range tests {
+		s := header
+		for nm, l := range tst.links {
+			s += fmt.Sprintf("\t{Name: %q, Link: %q},\n", nm, l)
+		}
+		s += "}\n"
+		err := ioutil.WriteFile(src, []byte(s), 0644)
+		if err != nil {
+			t.Fatalf("WriteFile(): %s", err)
+		}
+		out := filepath.Join(dir, fmt.Sprintf("out%d", i))
+		b, err := exec.Command("mkbundle/mkbundle", "extract",
+			src, out).CombinedOutput()
+		if !tst.ok {
+			if err == nil {
+				t.Fatalf("extract %v succeeded", tst.links)
+			}
+			// Nothing is written
+			if _, err = os.Lstat(out); err == nil {
+				t.Fatalf("extract %v: %s created", tst.links, out)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("extract %v: %s\n%s", tst.links, err, b)
+		}
+		b, err = ioutil.ReadFile(filepath.Join(out, "x"))
+		if err != nil || string(b) != "a" {
+			t.Fatalf("extract %v: x: %q %v", tst.links, b, err)
+		}
+	}
+}
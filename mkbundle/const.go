@@ -4,6 +4,12 @@ package main
 
 const usage = ` 
 Usage is: %[1]s [flags] <file-or-dir> 
+      or: %[1]s replace [flags] <executable> <file-or-dir>
+      or: %[1]s ls <bundle.go>
+      or: %[1]s cat <bundle.go> <name>...
+      or: %[1]s extract <bundle.go> <dir>
+      or: %[1]s diff <bundle.go|dir> <bundle.go|dir>
+      or: %[1]s textconv <bundle.go>
 
 Command "%[1]s" allows, moderately sized, arbitrary data files to be
 embedded (bundled) inside a Go binary. 
@@ -12,16 +18,18 @@ The following flags are recognized:
 
 `
 
+// Default import path of package bundle (see flag -import)
 const BundleImportPath string = "github.com/npat-efault/bundle"
 
+const GeneratedFormat string = "// Generated: %s\n"
+
 const BundleHeadFormat string = `
 // Bundle file
 // Auto-generated. !! DO NOT EDIT !!
-// Generated: %[5]s
-
+%[5]s
 package %[1]s
 
-import "%[4]s"
+import %[4]s
 
 var %[2]s = []bundle.Entry{
 `
@@ -31,15 +39,226 @@ const BundleFootFormat string = `}
 var %[2]s bundle.Index
 
 func init() {
-     %[2]s = bundle.MkIndex(%[1]s)
+	%[2]s = bundle.MkIndex(%[1]s)
 }
+`
 
+const BundleEndFormat string = `
 // End of bundle
 `
 
-const FileHeadFormat string = `{ Name : "%[1]s",
-  Size : %[2]d,
-  Gzip : %[3]v,
-  Data : ` + "`"
+const FileHeadFormat string = `	{
+		Name: %[1]q,
+		Size: %[2]d,
+		Gzip: %[3]v,%[4]s
+		Data: ` + "`"
+
+const FileFootFormat string = "\n`,\n\t},\n"
+
+const FileBlobFormat string = `	{
+		Name: %[1]q,
+		Size: %[2]d,
+		Gzip: %[3]v,%[7]s
+		Raw:  %[4]s[%[5]d:%[6]d],
+	},
+`
+
+const FileSharedFormat string = `	{
+		Name: %[1]q,
+		Size: %[2]d,
+		Gzip: %[3]v,%[5]s
+		Data: %[4]s,
+	},
+`
+
+const SharedHeadFormat string = "\nconst %[1]s = `"
+
+const SharedFootFormat string = "\n`\n"
+
+const SolidEntryFormat string = `	{
+		Name:  %[1]q,
+		Size:  %[2]d,
+		Chunk: &%[3]s[%[4]d],
+		Off:   %[5]d,
+	},
+`
+
+const ChunksHeadFormat string = `
+var %[1]s = [%[2]d]bundle.Chunk{
+`
+
+const ChunkHeadFormat string = `	{
+		Size: %[1]d,
+		Data: ` + "`"
+
+const ChunkFootFormat string = FileFootFormat
+
+const ChunkBlobFormat string = `	{
+		Size: %[1]d,
+		Raw:  %[2]s[%[3]d:%[4]d],
+	},
+`
+
+const ChunksFootFormat string = "}\n"
+
+const LinkFormat string = `	{
+		Name: %[1]q,
+		Link: %[2]q,
+	},
+`
+
+const DirFormat string = `	{
+		Name: %[1]q,
+		Mode: %#[2]x, // %[3]s
+	},
+`
+
+const BlobHeadFormat string = `
+const %[1]s = ""`
+
+const BlobLineLen int = 72
+
+const BlobPartLines int = 4096
+
+const BlobPartsFormat string = `
+const %[1]s = %[2]s
+`
+
+const hexDigits string = "0123456789abcdef"
+
+const RegionHeadFormat string = `
+// Bundle file
+// Auto-generated. !! DO NOT EDIT !!
+%[5]s
+package %[1]s
+
+import %[4]s
+
+// Reserved region holding the bundle container
+var %[2]s = [%[6]d]byte{`
+
+const RegionFootFormat string = `
+}
+
+var %[2]s bundle.Index
+
+func init() {
+	var err error
+	%[2]s, err = bundle.LoadRegion(%[1]s[:])
+	if err != nil {
+		panic(err)
+	}
+}
+`
+
+const RegionLineBytes int = 12
+
+const SplitHeadFormat string = `
+// Bundle file
+// Auto-generated. !! DO NOT EDIT !!
+%[5]s
+package %[1]s
+
+import %[4]s
+
+// Bundle entries are added to %[3]s by the init functions of the
+// bundle part files (%[6]s_*.go).
+var %[3]s = bundle.Index{}
+`
+
+const PkgHeadFormat string = `
+// Bundle file
+// Auto-generated. !! DO NOT EDIT !!
+%[5]s
+package %[1]s
+
+import (
+	%[4]s
+%[7]s)
+
+// Bundle entries are registered by the init functions of the bundle
+// part files (%[6]s_*.go), and of the imported packages.
+var %[3]s = bundle.Registered(%[2]q)
+`
+
+const PkgImportFormat string = "\t_ %q\n"
+
+const PartMarkerFormat string = "// Bundle file, part of %s\n"
+
+const PartHeadFormat string = `
+// Bundle file, part of %[6]s
+// Auto-generated. !! DO NOT EDIT !!
+%[5]s
+package %[1]s
+
+import %[4]s
+
+func init() {
+	%[3]s.Add([]bundle.Entry{
+`
+
+const RegisterHeadFormat string = `
+// Bundle file, part of %[6]s
+// Auto-generated. !! DO NOT EDIT !!
+%[5]s
+package %[1]s
+
+import %[4]s
+
+func init() {
+	bundle.Register(%[3]q, []bundle.Entry{
+`
+
+const PartFootFormat string = `	})
+}
+`
+
+const NamesHeadFormat string = `
+// Names of the bundle entries
+const (
+`
 
-const FileFootFormat string = "\n`},\n"
+const NameFormat string = "\t%[1]s = %[2]q\n"
+
+const NamesFootFormat string = ")\n"
+
+const AccessorFormat string = `
+// %[1]s returns the bundle entry %[2]q
+func %[1]s() *bundle.Entry { return %[3]s.Entry(%[2]q) }
+`
+
+const LazyHeadFormat string = `
+// Bundle file
+// Auto-generated. !! DO NOT EDIT !!
+%[5]s
+package %[1]s
+
+import %[4]s
+`
+
+const LazyEntryHeadFormat string = `
+var %[1]s = [1]bundle.Entry{
+`
+
+const LazyEntryFootFormat string = "}\n"
+
+const LazyIndexHeadFormat string = `
+var %[1]sLazy bundle.LazyIndex
+
+// %[1]s returns the bundle index. It is built on the first call.
+func %[1]s() bundle.Index {
+	return %[1]sLazy.Index(func() []*bundle.Entry {
+		return []*bundle.Entry{
+`
+
+const LazyIndexEntryFormat string = "\t\t\t&%[1]s[0],\n"
+
+const LazyIndexFootFormat string = `		}
+	})
+}
+`
+
+const LazyAccessorFormat string = `
+// %[1]s returns the bundle entry %[2]q
+func %[1]s() *bundle.Entry { return &%[3]s[0] }
+`
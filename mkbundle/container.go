@@ -0,0 +1,220 @@
+// Bundle container writer (for "-format=bin")
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/npat-efault/bundle"
+	"io"
+	"log"
+	"math"
+	"os"
+)
+
+// Container accumulates the entries of a bundle container. See
+// package bundle for a description of the container format.
+type Container struct {
+	recs []containerRec
+	data bytes.Buffer
+	seen map[containerKey]int // Stored data, by hash and codec
+}
+
+type containerKey struct {
+	sum   [sha256.Size]byte
+	codec byte
+}
+
+type containerRec struct {
+	name  string
+	codec byte
+	size  uint64
+	off   uint64
+	len   uint64
+	sum   [sha256.Size]byte
+}
+
+func NewContainer() *Container {
+	return &Container{seen: make(map[containerKey]int)}
+}
+
+// Add reads the data of an entry from "r", and adds the entry to the
+// container, compressing the data if "zip" is true. If an entry with
+// the same data is already in the container (and "-dedup" is on), the
+// data are stored only once, and both entries refer to them.
+func (c *Container) Add(r io.Reader, name string, zip bool) error {
+	var rec containerRec
+	var h = sha256.New()
+	var w io.Writer
+	var zw *gzip.Writer
+	var n int64
+	var err error
+
+	rec.name = name
+	rec.off = uint64(c.data.Len())
+	w = &c.data
+	rec.codec = bundle.CodecNone
+	if zip {
+		zw = gzip.NewWriter(&c.data)
+		w = zw
+		rec.codec = bundle.CodecGzip
+	}
+	n, err = io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return err
+	}
+	if zw != nil {
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+	}
+	rec.size = uint64(n)
+	rec.len = uint64(c.data.Len()) - rec.off
+	h.Sum(rec.sum[:0])
+	if fl.dedup {
+		key := containerKey{rec.sum, rec.codec}
+		if i, ok := c.seen[key]; ok {
+			dedupSaved += int(rec.size)
+			c.data.Truncate(int(rec.off))
+			rec.off, rec.len = c.recs[i].off, c.recs[i].len
+		} else {
+			c.seen[key] = len(c.recs)
+		}
+	}
+	c.recs = append(c.recs, rec)
+	return nil
+}
+
+// AddLink adds a link entry, pointing to "target", to the container.
+func (c *Container) AddLink(name, target string) {
+	var rec containerRec
+
+	rec.name = name
+	rec.codec = bundle.CodecLink
+	rec.off = uint64(c.data.Len())
+	c.data.WriteString(target)
+	rec.len = uint64(len(target))
+	c.recs = append(c.recs, rec)
+}
+
+// AddDir adds a directory entry, with mode "mode", to the container.
+func (c *Container) AddDir(name string, mode os.FileMode) {
+	var rec containerRec
+
+	rec.name = name
+	rec.codec = bundle.CodecDir
+	rec.size = uint64(mode &^ os.ModeDir)
+	rec.off = uint64(c.data.Len())
+	c.recs = append(c.recs, rec)
+}
+
+// WriteTo writes the container (header, index, and data region) to
+// "w". Returns an error, without writing anything, if an entry name
+// is too long to be stored in the index.
+func (c *Container) WriteTo(w io.Writer) (int64, error) {
+	var le = binary.LittleEndian
+	var idx, hdr []byte
+	var dataOff int
+	var n int
+	var err error
+
+	for _, r := range c.recs {
+		if len(r.name) > math.MaxUint16 {
+			return 0, fmt.Errorf("name too long: %.64s...", r.name)
+		}
+		idx = le.AppendUint16(idx, uint16(len(r.name)))
+		idx = append(idx, r.name...)
+		idx = append(idx, r.codec)
+		idx = le.AppendUint64(idx, r.size)
+		idx = le.AppendUint64(idx, r.off)
+		idx = le.AppendUint64(idx, r.len)
+		idx = append(idx, r.sum[:]...)
+	}
+	dataOff = bundle.ContainerHeaderSize + len(idx)
+	dataOff = (dataOff + bundle.ContainerAlign - 1) /
+		bundle.ContainerAlign * bundle.ContainerAlign
+
+	hdr = append(hdr, bundle.ContainerMagic...)
+	hdr = le.AppendUint32(hdr, bundle.ContainerVersion)
+	hdr = le.AppendUint32(hdr, uint32(len(c.recs)))
+	hdr = le.AppendUint64(hdr, uint64(bundle.ContainerHeaderSize))
+	hdr = le.AppendUint64(hdr, uint64(len(idx)))
+	hdr = le.AppendUint64(hdr, uint64(dataOff))
+	hdr = le.AppendUint64(hdr, uint64(c.data.Len()))
+
+	hdr = append(hdr, idx...)
+	hdr = append(hdr, make([]byte, dataOff-len(hdr))...)
+	n, err = w.Write(hdr)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(c.data.Bytes())
+	return int64(n + m), err
+}
+
+// appendBundle generates a bundle container for "fpath" and appends
+// it, followed by a trailer, to file "fname" (usually an executable).
+// If a container is already appended to the file, it is replaced. The
+// container is generated in memory, so that the file is left intact if
+// this fails.
+func appendBundle(fname, fpath string) error {
+	var f *os.File
+	var fi os.FileInfo
+	var buf bytes.Buffer
+	var off, end int64
+	var tr []byte
+	var err error
+
+	f, err = os.OpenFile(fname, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err = f.Stat()
+	if err != nil {
+		return err
+	}
+	end = fi.Size()
+	off, _, err = bundle.ReadTrailer(f, end)
+	if err == nil {
+		// Replace existing container
+		if fl.verbose {
+			log.Printf("Replacing bundle appended to %s", fname)
+		}
+		end = off
+	} else if !errors.Is(err, bundle.ErrFormat) {
+		return err
+	}
+	err = emitBundle(&buf, fpath)
+	if err != nil {
+		return err
+	}
+	// Align container start
+	off = (end + int64(bundle.ContainerAlign) - 1) /
+		int64(bundle.ContainerAlign) * int64(bundle.ContainerAlign)
+	err = f.Truncate(off)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	tr = binary.LittleEndian.AppendUint64(tr, uint64(off))
+	tr = binary.LittleEndian.AppendUint64(tr, uint64(buf.Len()))
+	tr = append(tr, bundle.TrailerMagic...)
+	_, err = buf.Write(tr)
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(f)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
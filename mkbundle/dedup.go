@@ -0,0 +1,178 @@
+// Emitting identical contents once (-dedup)
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Bytes of file data (before compression and encoding) not emitted
+// again, because identical contents were already emitted
+var dedupSaved int
+
+// Shared holds the data of the entries with identical contents, for
+// the "base64" layout. The data are emitted once, as string constants
+// that the entries refer to.
+type Shared struct {
+	prefix string            // Prefix of the constant names
+	names  map[string]string // Constant names (sans prefix), by path
+	offs   map[string]int    // Offsets of the emitted constants
+	buf    bytes.Buffer      // Constant declarations
+}
+
+// Data shared by entries with identical contents, for the "base64"
+// layout. Nil if no entries share their data.
+var shared *Shared
+
+// fileSum returns the SHA-256 hash of the contents of file "sf"
+func fileSum(sf srcFile) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	var f io.ReadCloser
+	var err error
+
+	f, err = sf.open()
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return sum, err
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// newShared hashes the contents of "files", and returns the shared
+// data for the ones with identical contents. Constants are named
+// "prefix" followed by (part of) the hash of their data. Returns nil
+// if no files have identical contents, if "-dedup" is off, or for
+// layouts other than "base64" (which deduplicate their data while
+// they are written).
+func newShared(files []srcFile, prefix string) (*Shared, error) {
+	var sums map[[sha256.Size]byte][]string
+	var s *Shared
+
+	if !fl.dedup || bin != nil || blob != nil {
+		return nil, nil
+	}
+	sums = make(map[[sha256.Size]byte][]string)
+	for _, f := range files {
+		if f.link != "" || f.mode.IsDir() {
+			continue
+		}
+		sum, err := fileSum(f)
+		if err != nil {
+			return nil, err
+		}
+		sums[sum] = append(sums[sum], f.path)
+	}
+	for sum, paths := range sums {
+		if len(paths) < 2 {
+			continue
+		}
+		if s == nil {
+			s = &Shared{names: make(map[string]string)}
+			s.reset(prefix)
+		}
+		for _, p := range paths {
+			s.names[p] = hex.EncodeToString(sum[:8])
+		}
+	}
+	return s, nil
+}
+
+// reset discards the emitted constants, so that the shared data can
+// be emitted again (e.g. in another part file), in constants named
+// with "prefix". Returns "s".
+func (s *Shared) reset(prefix string) *Shared {
+	if s == nil {
+		return nil
+	}
+	s.prefix = prefix
+	s.offs = make(map[string]int)
+	s.buf.Reset()
+	return s
+}
+
+// Len returns the size of the emitted constant declarations
+func (s *Shared) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.buf.Len()
+}
+
+// truncate discards the constants emitted after the first "n" bytes
+// of declarations.
+func (s *Shared) truncate(n int) {
+	if s == nil {
+		return
+	}
+	for name, off := range s.offs {
+		if off >= n {
+			delete(s.offs, name)
+		}
+	}
+	s.buf.Truncate(n)
+}
+
+// emitFile emits the entry for file "f", if its data are shared, and
+// reports if it did. The data are added to the shared constants the
+// first time they are seen.
+func (s *Shared) emitFile(w io.Writer, f srcFile) (bool, error) {
+	var gw io.WriteCloser
+	var in io.ReadCloser
+	var err error
+
+	if s == nil || s.names[f.path] == "" {
+		return false, nil
+	}
+	name := s.prefix + s.names[f.path]
+	_, err = fmt.Fprintf(w, FileSharedFormat,
+		f.name, f.size, fl.gzip, name, dictField())
+	if err != nil {
+		return true, err
+	}
+	if _, ok := s.offs[name]; ok {
+		dedupSaved += f.size
+		return true, nil
+	}
+	in, err = f.open()
+	if err != nil {
+		return true, err
+	}
+	defer in.Close()
+	n := s.buf.Len()
+	_, err = fmt.Fprintf(&s.buf, SharedHeadFormat, name)
+	if err != nil {
+		return true, err
+	}
+	if fl.gzip {
+		gw = newGoZipWriter(&s.buf, SharedFootFormat)
+	} else {
+		gw = newGoWriter(&s.buf, SharedFootFormat)
+	}
+	_, err = io.Copy(gw, in)
+	if err != nil {
+		gw.Close()
+		return true, err
+	}
+	err = gw.Close()
+	s.offs[name] = n
+	return true, err
+}
+
+// emitShared emits the shared data constants (if any)
+func emitShared(w io.Writer, s *Shared) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,190 @@
+// Compression with a shared preset dictionary (-dict)
+
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"container/heap"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+)
+
+// Parameters of the dictionary builder
+const (
+	dictGram     int = 8        // Length of the substrings counted
+	dictSegment  int = 64       // Length of dictionary segments
+	dictMaxFile  int = 64 << 10 // Bytes sampled from every file
+	dictMaxTotal int = 32 << 20 // Total bytes sampled
+	dictMaxSize  int = 32 << 10 // Max dictionary size (DEFLATE window)
+)
+
+// Preset compression dictionary (for "-dict"). Nil if not used.
+var dict []byte
+
+// dictName returns the name of the constant holding the dictionary
+func dictName() string {
+	return fl.bundle + "Dict"
+}
+
+// dictField returns the Dict field of the entries (or an empty
+// string, if no dictionary is used), to be inserted in the entry
+// formats.
+func dictField() string {
+	if dict == nil {
+		return ""
+	}
+	return "\n\t\tDict: " + dictName() + ","
+}
+
+// newCompressor returns a writer that compresses the data written to
+// it, and writes them to "w": With gzip, or with raw DEFLATE and the
+// preset dictionary, if one is used.
+func newCompressor(w io.Writer) io.WriteCloser {
+	if dict == nil {
+		return gzip.NewWriter(w)
+	}
+	// Cannot fail, the level is valid
+	fw, _ := flate.NewWriterDict(w, flate.DefaultCompression, dict)
+	return fw
+}
+
+// gramHash returns the hash of substring "g"
+func gramHash(g []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(g)
+	return h.Sum64()
+}
+
+// A dictSeg is a candidate dictionary segment
+type dictSeg struct {
+	data  []byte
+	score int
+}
+
+// segHeap is a max-heap of segments, by score
+type segHeap []*dictSeg
+
+func (h segHeap) Len() int            { return len(h) }
+func (h segHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h segHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *segHeap) Push(x interface{}) { *h = append(*h, x.(*dictSeg)) }
+func (h *segHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// segScore returns the score of segment "seg": The number of other
+// files containing each of its substrings, summed.
+func segScore(seg []byte, counts map[uint64]int) int {
+	var score int
+
+	for i := 0; i+dictGram <= len(seg); i++ {
+		if n := counts[gramHash(seg[i:i+dictGram])]; n > 1 {
+			score += n - 1
+		}
+	}
+	return score
+}
+
+// mkDict builds a preset dictionary, of up to "size" bytes, from the
+// contents of "files". Substrings found in many files make the best
+// dictionary: The files are cut in segments, every segment is scored
+// by how many other files contain its substrings, and the best
+// segments are put in the dictionary. Substrings already in the
+// dictionary do not count again, so that its contents are varied.
+// The best segments are placed last, closest to the data, where
+// matches are cheaper to encode.
+func mkDict(files []srcFile, size int) ([]byte, error) {
+	var samples [][]byte
+	var counts map[uint64]int
+	var segs segHeap
+	var total int
+	var d []byte
+
+	for _, f := range files {
+		if f.link != "" || f.mode.IsDir() || total >= dictMaxTotal {
+			continue
+		}
+		b, err := readSample(f, dictMaxFile)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, b)
+		total += len(b)
+	}
+
+	// Count the files containing every substring
+	counts = make(map[uint64]int)
+	for _, b := range samples {
+		seen := make(map[uint64]bool)
+		for i := 0; i+dictGram <= len(b); i++ {
+			h := gramHash(b[i : i+dictGram])
+			if !seen[h] {
+				seen[h] = true
+				counts[h]++
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, b := range samples {
+		for off := 0; off < len(b); off += dictSegment {
+			end := off + dictSegment
+			if end > len(b) {
+				end = len(b)
+			}
+			seg := b[off:end]
+			if used[string(seg)] {
+				continue
+			}
+			used[string(seg)] = true
+			if score := segScore(seg, counts); score > 0 {
+				segs = append(segs, &dictSeg{seg, score})
+			}
+		}
+	}
+	heap.Init(&segs)
+	for segs.Len() > 0 && len(d) < size {
+		s := heap.Pop(&segs).(*dictSeg)
+		// Rescore, as substrings may have been covered since
+		score := segScore(s.data, counts)
+		if score <= 0 || len(d)+len(s.data) > size {
+			continue
+		}
+		if segs.Len() > 0 && score < segs[0].score {
+			s.score = score
+			heap.Push(&segs, s)
+			continue
+		}
+		d = append(append([]byte{}, s.data...), d...)
+		for i := 0; i+dictGram <= len(s.data); i++ {
+			delete(counts, gramHash(s.data[i:i+dictGram]))
+		}
+	}
+	return d, nil
+}
+
+// readSample reads (up to) the first "max" bytes of file "sf"
+func readSample(sf srcFile, max int) ([]byte, error) {
+	f, err := sf.open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(io.LimitReader(f, int64(max)))
+}
+
+// emitDict emits the dictionary (if one is used) as a string
+// constant.
+func emitDict(w io.Writer) error {
+	if dict == nil {
+		return nil
+	}
+	b := NewBlob(dictName())
+	b.buf.Write(dict)
+	return emitBlob(w, b)
+}
@@ -0,0 +1,158 @@
+// mkbundle diff and textconv subcommands
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/npat-efault/bundle"
+	"os"
+	"unicode/utf8"
+)
+
+// loadIndex returns the index of the bundle "arg". If "arg" is a
+// directory, the index is generated (in memory) from the files in
+// it, as it would be by mkbundle. Otherwise "arg" is parsed as a
+// generated bundle file.
+func loadIndex(arg string) (bundle.Index, error) {
+	var buf bytes.Buffer
+	var err error
+
+	fi, err := os.Stat(arg)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return parseBundle(arg)
+	}
+	defer func(b *Container) { bin = b }(bin)
+	bin = NewContainer()
+	err = emitBundle(&buf, arg)
+	if err != nil {
+		return nil, err
+	}
+	return bundle.Load(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+// isText reports if "data" looks like text: Valid UTF-8 without NUL
+// characters.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// cmdDiff implements:
+//
+//	mkbundle diff <a> <b>
+//
+// Where <a> and <b> are generated bundle files, or directories. It
+// reports the entries added, removed and modified in <b> relative to
+// <a>, with their sizes. For modified text entries, a unified diff is
+// also printed.
+func cmdDiff(args []string) error {
+	var a, b bundle.Index
+	var err error
+
+	if len(args) != 2 {
+		return errors.New("usage: diff <a> <b>")
+	}
+	a, err = loadIndex(args[0])
+	if err != nil {
+		return err
+	}
+	b, err = loadIndex(args[1])
+	if err != nil {
+		return err
+	}
+	for _, e := range allEntries(a) {
+		be, ok := b[e.Name]
+		if !ok {
+			fmt.Printf("D %s (-%d)\n", e.Name, e.Size)
+			continue
+		}
+		if e.Mode != be.Mode {
+			fmt.Printf("M %s mode %s -> %s\n",
+				e.Name, e.Mode, be.Mode)
+		}
+		if e.IsDir() || be.IsDir() {
+			continue
+		}
+		if e.Link != "" || be.Link != "" {
+			if e.Link != be.Link {
+				fmt.Printf("M %s link %q -> %q\n",
+					e.Name, e.Link, be.Link)
+			}
+			continue
+		}
+		ad, err := e.Decode(0)
+		if err != nil {
+			return err
+		}
+		bd, err := be.Decode(0)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(ad, bd) {
+			continue
+		}
+		fmt.Printf("M %s %d -> %d (%+d)\n",
+			e.Name, len(ad), len(bd), len(bd)-len(ad))
+		if isText(ad) && isText(bd) {
+			err = unifiedDiff(os.Stdout,
+				"a/"+e.Name, "b/"+e.Name,
+				string(ad), string(bd))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range allEntries(b) {
+		if _, ok := a[e.Name]; !ok {
+			fmt.Printf("A %s (+%d)\n", e.Name, e.Size)
+		}
+	}
+	return nil
+}
+
+// cmdTextconv implements:
+//
+//	mkbundle textconv <bundle.go>
+//
+// It prints a text representation of the bundle, suitable for use as
+// a git "textconv" filter: For every entry, a header line with its
+// name, size and checksum, followed by its contents (for text
+// entries).
+func cmdTextconv(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: textconv <bundle.go>")
+	}
+	idx, err := loadIndex(args[0])
+	if err != nil {
+		return err
+	}
+	for _, e := range allEntries(idx) {
+		if e.Link != "" {
+			fmt.Printf("=== %s -> %s\n", e.Name, e.Link)
+			continue
+		}
+		if e.IsDir() {
+			fmt.Printf("=== %s/ (%s)\n", e.Name, e.Mode)
+			continue
+		}
+		data, err := e.Decode(0)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("=== %s (%d bytes, sha256 %x)\n",
+			e.Name, len(data), sha256.Sum256(data))
+		if !isText(data) {
+			continue
+		}
+		os.Stdout.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			fmt.Println()
+		}
+	}
+	return nil
+}
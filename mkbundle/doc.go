@@ -12,29 +12,134 @@ can be compiled and linked with the rest of your program's code. Usage is:
 
 The following flags are recognized:
 
+  -D=: Define variable for the expand filter: NAME=VALUE
   -a=false: Short for "-always"
+  -allowundef=false: Leave undefined variables unexpanded (instead of failing)
   -always=false: Regenerate output even if younger than input
+  -append=false: Append container to output file (-format=bin)
   -bundle="_bundle": Name of global that keeps embedded data
+  -dedup=false: Emit the data of identical files once
+  -dict=0: Compress with a shared dictionary of this size (bytes)
+  -dirs=false: Record directory entries (with their modes)
+  -exclude=: Exclude files/dirs (gitignore-style pattern)
+  -filter=: Filter files matching a pattern: <pattern>=<filter>
+  -format="go": Output format: "go" or "bin" (container)
   -g=false: Short for '-gzip'
   -gzip=false: Compress data before embedding
   -h=false: Short for "-help"
   -help=false: Show instructions
+  -ignorefile=".bundleignore": Name of per-directory ignore files
+  -import="github.com/npat-efault/bundle": Import path of package bundle
+  -include=: Re-include excluded files/dirs (pattern)
   -index="_bundleIdx": Name of global filename-to-data index
+  -layout="base64": Data layout: "base64" or "blob"
+  -lazy=false: Separate entry variables, index built on first use
+  -manifest="": File with variables for the expand filter (NAME=VALUE lines)
+  -names="": Emit entry names: "const" or accessor "func"
+  -maxsize=0: Max size of split output files (bytes)
   -o="": Short for "-out"
   -out="": Output file (if empty, use <stdout>)
   -pkg="main": Package for the generated source file
-  -skip=[]: Files/dirs to skip (glob pattern)
+  -pkgpath="": Import path of the output directory (for -split=pkg)
+  -prefix="Asset": Prefix of entry name constants or accessors
+  -reserve=0: Size of reserved region for the bundle (bytes)
+  -skip=: Same as "-exclude"
+  -solid=0: Compress entries together, in chunks of this size (bytes)
+  -split="": Split output per "entry", "dir", "size", or "pkg"
+  -stable=false: Sort entries and omit timestamp (git-friendly)
+  -symlinks="skip": Symbolic links: "skip", "follow", or "link"
   -v=false: Short for "-verbose"
   -verbose=false: Print actions performed on <stderr>
 
 The <file-or-dir> argument is the name (path) of the file you wish to
 embed. If a directory name is given instead, all files in that
-directory (and its subdirectories, recursively) will be embedded. The
-'-skip' flag can be used to specify files and directories that will be
-skipped when generating the bundle. The argument of the '-skip' flag
-is interpreted as a glob pattern. The '-skip' flag can be given
-multiple times, if files and directories matching multiple patterns
-must be skipped.
+directory (and its subdirectories, recursively) will be embedded,
+except for those excluded by the include / exclude rules.
+
+Rules are given by the '-exclude' and '-include' flags (which can be
+given multiple times), and by ignore files (named ".bundleignore", or
+as given by the '-ignorefile' flag; an empty name disables them)
+found in the directories walked. Rules follow the syntax of
+.gitignore files: Patterns are matched against the path of files
+and directories relative to <file-or-dir> (or to the directory of
+the ignore file); a pattern without a slash matches at any depth,
+while a pattern with a slash (e.g. "docs/drafts/*.md", or "/build")
+is anchored. A "**" path element matches any number of directories.
+A pattern ending with a slash matches only directories, and a
+pattern starting with "!" re-includes what previous rules excluded
+('-include=pattern' is the same as '-exclude=!pattern'). Rules are
+considered in order: first the flags, as given, then the ignore
+files, from the top directory down; the last rule that matches a
+file or directory decides. If a directory is excluded, its contents
+are not walked, so files in it cannot be re-included. For example,
+to skip all draft documents, except for the index:
+
+  mkbundle -exclude='docs/drafts/*.md' -include='index.md' site
+
+Ignore files themselves are not bundled. The '-skip' flag is the
+same as '-exclude'.
+
+The contents of files can be transformed before they are bundled,
+with the '-filter' flag (which can be given multiple times). Its
+argument has the form <pattern>=<filter>, where the pattern is like
+those of the include / exclude rules (matched against the names of
+the entries), and the filter is one of:
+
+  json    Remove insignificant whitespace from JSON data
+  crlf    Convert CRLF line endings to LF
+  bom     Remove the UTF-8 byte order mark, if present
+  trim    Remove trailing spaces and tabs from every line
+  expand  Replace ${NAME} placeholders with the values of variables
+  |cmd    Run command "cmd" (with its arguments, separated by
+          spaces), with the data on its standard input, and use its
+          standard output instead
+
+All the filters whose patterns match a file are applied, in the
+order given. For example, to strip the byte order mark from JSON
+files and minify them, and to minify SVG images with an external
+tool:
+
+  mkbundle -filter='*.json=bom' -filter='*.json=json' \
+      -filter='*.svg=|svgo -i - -o -' assets
+
+The bundled data, and the sizes recorded in the entries, are those
+of the filtered contents. If a filter fails (e.g. for invalid JSON
+data, or if the command exits with an error), the command fails.
+
+The "expand" filter is used to embed build-time values (e.g. a
+version string) in text files. Placeholders have the form ${NAME},
+where NAME consists of letters, digits and underscores; other text
+(including $NAME) is left as it is, and "$${" stands for a literal
+"${". Variables are defined with the '-D' flag (which can be given
+multiple times), in the manifest file given by the '-manifest' flag,
+or in the environment, and are looked up in this order. The manifest
+file holds a NAME=VALUE definition per line; blank lines, and lines
+starting with "#", are ignored. A placeholder for an undefined
+variable is an error, unless the '-allowundef' flag is given, in
+which case it is left as it is. Only text files (valid UTF-8,
+without NUL characters) can be expanded. For example:
+
+  mkbundle -D VERSION=1.4.2 -D BUILD=$(git rev-parse --short HEAD) \
+      -filter='about.html=expand' -filter='*.tmpl=expand' assets
+
+Normally only files are bundled, and directories are implied by the
+names of the files in them; empty directories are lost. If the
+'-dirs' flag is given, a directory entry, recording the directory's
+mode, is also bundled for every directory walked (including empty
+ones). Directory entries are reported by the Stat method of the
+index, and are extracted as directories (see type Entry in package
+bundle).
+
+The '-symlinks' flag selects how symbolic links found while walking
+directories are handled. With "skip" (the default) they are skipped.
+With "follow" links to files are bundled as the files they point to,
+and links to directories are walked as if they were directories; a
+link to a directory that is already being walked (which would form a
+cycle) is skipped, with a warning. With "link" the links are bundled
+as link entries, recording the link targets (see field Entry.Link in
+package bundle). Link entries are resolved to their targets by index
+lookups. A warning is printed for links that point outside the
+bundle, which cannot be resolved.
 
 The '-pkg' flag provides the name of the package the generated file
 will belong to. The '-bundle' flag provides the name of the global
@@ -47,9 +152,252 @@ mkbundle command. If a single file (not directory) name is given as
 argument to the command, then the file-name in the index will be the
 base-name of that single file.
 
+The generated file imports package bundle from the path given by the
+'-import' flag (by default "github.com/npat-efault/bundle"). This can
+be used to generate bundles for a fork of the package, or for a copy
+of it vendored under a different import path. The package is always
+referred to as "bundle" in the generated code. Generated files are
+formatted with go/format (as "gofmt" would). If the generated code
+cannot be parsed (e.g. because of an invalid '-pkg' name), the
+command fails, and no output file is written.
+
 If the '-gzip' flag is given, then files will be compressed with gzip
 before being embedded.
 
+Small files compress poorly on their own, as the compressor has
+little data to find repetitions in. If the '-dict' flag is given
+(with a size of up to 32768 bytes), a preset dictionary of that size
+is built from the substrings most common among the files bundled,
+and every file is compressed (with raw DEFLATE, instead of gzip)
+using it. The dictionary is emitted once, as a string constant
+(named after the '-bundle' variable, with the "Dict" suffix), and is
+shared by all the entries (see field Entry.Dict in package bundle).
+Entries are still decompressed individually, when they are opened
+or decoded. For a sample tree of 400 small generated files (300 JSON
+documents of about 700 bytes, and 100 HTML pages of about 1200
+bytes, with common structure), the sizes of the embedded data
+(without the base64 encoding, i.e. with the "blob" layout) were:
+
+  Flags            Data  Dictionary   Total
+  (none)         330081           -  330081
+  -gzip          167851           -  167851
+  -dict=4096      86885        4090   90975
+  -dict=16384     72986       16378   89364
+  -dict=32768     66226       32768   98994
+
+A larger dictionary helps more the more files there are, since it is
+stored only once. The '-dict' flag cannot be used with '-format=bin',
+'-reserve', or '-split=pkg'.
+
+For large collections of text, compressing the files together
+("solid" compression) gives an even better ratio. If the '-solid'
+flag is given, the data of consecutive files are concatenated in
+chunks of (about) the given size, and every chunk is compressed with
+gzip, as a whole. The chunks are emitted as an array (named after the
+'-bundle' variable, with the "Chunks" suffix), and every entry refers
+to its chunk, and to the offset of its data in it (see type Chunk in
+package bundle). Files larger than the chunk size get a chunk of
+their own. When an entry is accessed, only its chunk is
+decompressed; smaller chunks make random accesses cheaper, at some
+cost in compression. Decompressed chunks can be kept in a cache, so
+that accesses to other entries in them are fast (see ChunkCacheSize
+in package bundle). For the sample tree above, the sizes of the
+compressed data were:
+
+  Flags             Data  Chunks
+  -gzip           167851       -
+  -solid=16384     74413      20
+  -solid=65536     64913       5
+  -solid=262144    62484       2
+
+The sample tree, and the tables above, are generated by the program
+in file mksizes.go (run "./all.sh sizes" at the top of the
+repository).
+
+The '-solid' flag cannot be used with '-format=bin', '-reserve',
+'-lazy', '-split', or '-dict'.
+
+The '-layout' flag selects how the data of the embedded files are
+stored in the generated file. With the default "base64" layout, the
+data of every file are base64 encoded and stored in a separate string
+literal. With the "blob" layout, the data of all files are stored,
+not encoded, in a single string constant (named after the '-bundle'
+variable, with the "Blob" suffix), and every entry refers to its
+data by offset and length in this constant. Generated files using
+the "blob" layout compile faster, and the data of uncompressed
+entries can be accessed without decoding or copying them (see method
+Entry.Direct in package bundle).
+
+With '-dedup', files with identical contents (e.g. the same icon under
+several themes) are detected by their SHA-256 hash, and their data are
+emitted only once; the entries for all of them share the data, and are
+otherwise the same as if each had its own copy. With the "base64"
+layout, the shared data are emitted as a string constant (named after
+the '-bundle' variable, with the "Data_" suffix, followed by part of
+the hash), that the entries refer to. With the "blob" layout, and in
+containers ("-format=bin"), the entries refer to the same data by
+offset and length. When the output is split (see below), data are
+shared only by entries in the same part file. With '-verbose', the
+size of the file data not emitted again (before compression and
+encoding) is reported. Without '-dedup', a copy of the data is emitted
+for every file.
+
+Entries are looked up in the index by name, so a misspelled name is
+only detected when the program runs. If the '-names' flag is given,
+identifiers for the entry names are also emitted in the generated
+file, and the program can use them instead of string literals, so
+that references to missing entries are compile errors. With
+"-names=const" a string constant is emitted for every entry name,
+and with "-names=func" an accessor function returning the entry:
+
+  const AssetTmplIndexHTML = "tmpl/index.html"
+
+  func AssetTmplIndexHTML() *bundle.Entry
+
+The identifiers start with the prefix given by the '-prefix' flag,
+followed by the words of the entry name (separated by any characters
+other than letters and digits), each with its first letter
+capitalized. Common initialisms (like "HTML", "JSON", "PNG") are
+spelled all-caps. If several names map to the same identifier, the
+first one (in sorted order) gets the identifier, and the others get
+a numeric suffix ("_2", "_3", etc.); a warning is printed for every
+such collision.
+
+Normally all entries are kept in a single slice, which is used to
+build the index when the program starts. As a result, all bundled
+files are linked in every program that includes the bundle, even if
+it uses only a few of them. If the '-lazy' flag is given, every entry
+is kept in a separate variable, and, instead of the index variable,
+a function with the same name is emitted, which builds the index on
+its first call (see type LazyIndex in package bundle):
+
+  func _bundleIdx() bundle.Index
+
+Together with "-names=func", the accessors return the entry variables
+directly. A program that accesses entries only through accessors (and
+never calls the index function) is linked only with the entries it
+uses; the linker drops the rest. The accessors of link entries are the
+exception: they return the target of the link, like Index.Entry, so
+they call the index function. The '-lazy' flag cannot be used with the
+"blob" layout, '-split', '-reserve', or '-format=bin'.
+
+The '-format' flag selects the format of the output. With the
+default "go" format, a Go source file is generated, as described
+above. With the "bin" format, a binary bundle container file is
+generated instead. Container files are not compiled in the program;
+they can be loaded at runtime using functions Load and LoadFile of
+package bundle. This way the bundled files can be updated without
+rebuilding the program. The '-pkg', '-bundle', '-index', and
+'-layout' flags are ignored for the "bin" format.
+
+If the '-append' flag is given (together with '-format=bin'), the
+bundle container is appended to the output file, which must already
+exist, instead of replacing it. This is used to attach a bundle to
+an already built executable, which can then access it using function
+OpenSelf of package bundle. A short trailer, written after the
+container, allows the program to locate it. If a container is
+already appended to the file, it is replaced.
+
+If the '-reserve' flag is given (with the default "go" format), the
+generated file stores the bundle as a bundle container, inside a
+reserved region (a byte array) of the given size. The unused part of
+the region is padded with zeros. The data of such a bundle can later
+be replaced directly in the compiled executable, using the "replace"
+subcommand:
+
+  mkbundle replace [flags] <executable> <file-or-dir>
+
+This generates a new bundle from <file-or-dir> (honoring flags such
+as '-gzip' and '-exclude') and writes it in the reserved region of
+<executable>. The command refuses to modify the executable if the new
+bundle does not fit in the region. The "replace" subcommand can also
+replace a bundle appended to the executable (see '-append'). In
+both cases the result is verified before, and after, the executable
+is replaced.
+
+Generated files are meant to be committed to version control, along
+with the rest of the program's code. If the '-stable' flag is given,
+the output depends only on the bundled files: Entries are sorted by
+name, and the timestamp is omitted from the header. This way
+regenerating a bundle from the same files gives the same output, and
+changing a file changes only the lines of its own entry (with the
+default "base64" layout; with the "blob" layout, the offsets of the
+entries following it change as well).
+
+The '-split' flag (which requires '-out') spreads the generated code
+over several files, so that unchanged files never show up in diffs.
+With "-split=entry" a part file is generated for every bundled file,
+and with "-split=dir" for every directory (holding the entries of
+the files directly in it). The main output file declares only the
+index; the part files are named after it (e.g. "mybundle_<part>.go"
+for "mybundle.go"), and add their entries to the index from their
+"init" functions (see method Index.Add in package bundle). Part files
+left over from previous runs (e.g. for removed directories) are
+deleted. For the "blob" layout, every part file has its own blob
+constant. Split bundles cannot be used with '-format=bin' or
+'-reserve'.
+
+Very large generated files slow down the compiler and editors. With
+"-split=size" the entries are spread over part files holding up to
+'-maxsize' bytes of generated code each (a part holding a single
+entry can be larger). The part files are numbered in order (e.g.
+"mybundle_001.go", "mybundle_002.go").
+
+With "-split=pkg" the entries under every top-level directory of
+<file-or-dir> are emitted in a separate package, in a subdirectory
+(named after the top-level directory) of the directory of the output
+file. The '-pkgpath' flag gives the import path of the directory of
+the output file (e.g. "example.com/app/assets"), so that the main
+output file can import the packages. The generated packages register
+their entries with package bundle (see function Register), and the
+index declared in the main output file is merged from them, during
+initialization. Entries not in any directory are emitted in part
+files next to the main output file. If '-maxsize' is also given,
+the entries of every package are spread over several files, as with
+"-split=size". Output files generated with "-split=pkg" cannot be
+test files ("_test.go"). Top-level directories whose (lower-cased,
+mangled) names are Go keywords, or "main", are rejected, as they
+cannot name packages.
+
+The contents of a generated bundle file can be inspected using the
+following subcommands:
+
+  mkbundle ls <bundle.go>
+  mkbundle cat <bundle.go> <name>...
+  mkbundle extract <bundle.go> <dir>
+
+"ls" lists the entries in the bundle (size, "z" if compressed, "l" for
+link entries, or "d" for directory entries, and name), "cat" writes
+the data of the named entries to <stdout>, and "extract" writes all
+entries as files under <dir> (link entries are extracted as symbolic
+links; bundles with links pointing outside the bundle, once extracted,
+or with entries under link entries, are refused; directory entries are
+extracted as directories, with their recorded modes). The bundle file
+is parsed (it is not compiled), together with its part files, if it
+was generated with '-split', and its entries are decoded using package
+bundle.
+
+Two bundles can be compared using the "diff" subcommand:
+
+  mkbundle diff <a> <b>
+
+Where <a> and <b> are generated bundle files, or directories (which
+are bundled in memory, honoring the include / exclude rules). The
+command reports the entries that were added ("A"), removed ("D"), or
+modified ("M") in <b> relative to <a>, together with their size
+changes. For modified text entries, a unified diff is also printed. If
+the texts differ in too many lines, the diff simply replaces all lines
+of the old text with the lines of the new one.
+
+The "textconv" subcommand prints a text representation of a bundle:
+a line with the name, size and SHA-256 checksum of every entry,
+followed by the entry's contents, if it is text. It can be used as a
+git "textconv" filter, so that "git diff" shows meaningful changes
+for generated bundle files:
+
+  $ echo "mybundle.go diff=mkbundle" >> .gitattributes
+  $ git config diff.mkbundle.textconv "mkbundle textconv"
+
 If the '-verbose' flag is given, then the command will print a few
 short messages on <stderr> indicating the actions it performs. Without
 '-verbose' the commands prints messages only on errors, otherwise it
@@ -57,8 +405,11 @@ remains completely silent.
 
 If the output file (specified by the "-out" flag) already exists, it
 will be re-generated only if <file-or-dir>, or at least one of the
-files and sub-directories in it, are younger than the output file. You
-can override this behavior using the "-always" flag.
+files and sub-directories in it, or the manifest file, are younger
+than the output file. You can override this behavior using the
+"-always" flag. Bundles generated with '-D' flags, or with the
+"expand" filter or filter commands (whose output may change without
+their inputs changing), are always re-generated.
 
 For information on how to access the bundled data from your code, see
 the documentation of package:
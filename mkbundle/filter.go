@@ -0,0 +1,375 @@
+// Build-time filters (-filter)
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A filter transforms the contents of the files matching a pattern
+type filter struct {
+	r    rule   // Files the filter applies to
+	name string // Built-in filter name, or "|command"
+	fn   func(data []byte) ([]byte, error)
+}
+
+// Built-in filters, by name
+var builtinFilters = map[string]func(data []byte) ([]byte, error){
+	"json":   compactJSON,
+	"crlf":   crlfToLF,
+	"bom":    stripBOM,
+	"trim":   trimSpace,
+	"expand": expandVars,
+}
+
+// compactJSON removes insignificant whitespace from JSON data
+func compactJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := json.Compact(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// crlfToLF converts CRLF line endings to LF
+func crlfToLF(data []byte) ([]byte, error) {
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), nil
+}
+
+// stripBOM removes the UTF-8 byte order mark, if present
+func stripBOM(data []byte) ([]byte, error) {
+	return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), nil
+}
+
+// trimSpace removes trailing spaces and tabs from every line. Line
+// endings (LF or CRLF) are kept as they are.
+func trimSpace(data []byte) ([]byte, error) {
+	var lines [][]byte
+
+	lines = bytes.Split(data, []byte("\n"))
+	for i, l := range lines {
+		cr := bytes.HasSuffix(l, []byte("\r"))
+		l = bytes.TrimRight(bytes.TrimSuffix(l, []byte("\r")), " \t")
+		if cr {
+			l = append(l, '\r')
+		}
+		lines[i] = l
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// cmdFilter returns a filter function that runs command "args", with
+// the data on its standard input, and returns its standard output.
+func cmdFilter(args []string) func(data []byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		var out bytes.Buffer
+
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout = &out
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+}
+
+// parseFilter parses filter specification "spec", of the form
+// <pattern>=<filter>. The pattern is like the ones of the include /
+// exclude rules. The filter is the name of a built-in filter, or
+// "|" followed by a command (and its arguments, separated by
+// spaces).
+func parseFilter(spec string) (filter, error) {
+	var flt filter
+	var ok bool
+	var err error
+
+	i := strings.Index(spec, "=")
+	if i < 0 {
+		return flt, fmt.Errorf("bad filter %q: no \"=\"", spec)
+	}
+	pat, name := spec[:i], spec[i+1:]
+	if strings.HasPrefix(pat, "!") {
+		return flt, fmt.Errorf("bad filter %q: negated pattern", spec)
+	}
+	flt.r, ok, err = parseRule(pat, "")
+	if err != nil {
+		return flt, err
+	}
+	if !ok || flt.r.dir {
+		return flt, fmt.Errorf("bad filter %q: bad pattern", spec)
+	}
+	flt.name = name
+	if strings.HasPrefix(name, "|") {
+		args := strings.Fields(name[1:])
+		if len(args) == 0 {
+			return flt, fmt.Errorf("bad filter %q: no command",
+				spec)
+		}
+		flt.fn = cmdFilter(args)
+		return flt, nil
+	}
+	flt.fn, ok = builtinFilters[name]
+	if !ok {
+		return flt, fmt.Errorf("bad filter %q: unknown filter %q",
+			spec, name)
+	}
+	return flt, nil
+}
+
+// flagFilters returns the filters given by the "-filter" flags, in
+// the order given.
+func flagFilters() ([]filter, error) {
+	var filters []filter
+
+	for _, spec := range fl.filters {
+		flt, err := parseFilter(spec)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, flt)
+	}
+	if fl.varfile != "" && manifest == nil {
+		vars, err := readManifest(fl.varfile)
+		if err != nil {
+			return nil, err
+		}
+		manifest = vars
+	}
+	return filters, nil
+}
+
+// volatileInputs reports if the bundle depends on inputs whose
+// changes cannot be detected by their modification times: The "-D"
+// flags, and the environment and output of commands used by the
+// filters. If so, the bundle must always be regenerated.
+func volatileInputs() bool {
+	if len(fl.defines) > 0 {
+		return true
+	}
+	for _, spec := range fl.filters {
+		i := strings.Index(spec, "=")
+		if i < 0 {
+			continue
+		}
+		name := spec[i+1:]
+		if name == "expand" || strings.HasPrefix(name, "|") {
+			return true
+		}
+	}
+	return false
+}
+
+// filterFiles applies the filters given by the "-filter" flags to the
+// regular files in "files". All the filters matching the name of a
+// file are applied, in order. The filtered contents (and their size)
+// replace the contents of the file in the bundle.
+func filterFiles(files []srcFile) error {
+	var filters []filter
+	var err error
+
+	filters, err = flagFilters()
+	if err != nil || len(filters) == 0 {
+		return err
+	}
+	for i, f := range files {
+		if f.link != "" || f.mode.IsDir() {
+			continue
+		}
+		var data []byte
+		for _, flt := range filters {
+			if !flt.r.match(f.name, false) {
+				continue
+			}
+			if data == nil {
+				data, err = ioutil.ReadFile(f.path)
+				if err != nil {
+					return err
+				}
+			}
+			data, err = flt.fn(data)
+			if err != nil {
+				return fmt.Errorf("%s: filter %s: %s",
+					f.name, flt.name, err)
+			}
+			if data == nil {
+				data = []byte{}
+			}
+		}
+		if data == nil {
+			continue
+		}
+		if fl.verbose {
+			log.Printf("Filtered %s (%d to %d bytes)",
+				f.name, f.size, len(data))
+		}
+		files[i].data = data
+		files[i].size = len(data)
+	}
+	return nil
+}
+
+// filterFlag is the flag.Value of the "-filter" flag, which can be
+// given multiple times.
+type filterFlag struct{}
+
+func (ff filterFlag) String() string {
+	return ""
+}
+
+func (ff filterFlag) Set(value string) error {
+	fl.filters = append(fl.filters, value)
+	return nil
+}
+
+// Variables for the "expand" filter, given by "-D" flags, and read
+// from the "-manifest" file (loaded by flagFilters).
+var manifest map[string]string
+
+// lookupVar returns the value of variable "name": As given by a "-D"
+// flag, or else as given in the manifest, or else from the
+// environment.
+func lookupVar(name string) (string, bool) {
+	if v, ok := fl.defines[name]; ok {
+		return v, true
+	}
+	if v, ok := manifest[name]; ok {
+		return v, true
+	}
+	return os.LookupEnv(name)
+}
+
+// isVarName reports if "s" is a valid variable name: letters, digits
+// and underscores, not starting with a digit.
+func isVarName(s string) bool {
+	for i, c := range []byte(s) {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// expandVars replaces ${NAME} placeholders in (text) data with the
+// values of the variables (see lookupVar). "$${" is replaced by a
+// literal "${". Undefined variables are errors, unless "-allowundef"
+// is given, in which case their placeholders are left as they are.
+func expandVars(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	var p int
+
+	if !isText(data) {
+		return nil, errors.New("not a text file")
+	}
+	for {
+		i := bytes.Index(data[p:], []byte("${"))
+		if i < 0 {
+			break
+		}
+		i += p
+		if i > 0 && data[i-1] == '$' {
+			// Escaped
+			out.Write(data[p : i-1])
+			out.WriteString("${")
+			p = i + 2
+			continue
+		}
+		j := bytes.IndexByte(data[i+2:], '}')
+		if j < 0 || !isVarName(string(data[i+2:i+2+j])) {
+			// Not a placeholder
+			out.Write(data[p : i+2])
+			p = i + 2
+			continue
+		}
+		name := string(data[i+2 : i+2+j])
+		v, ok := lookupVar(name)
+		if !ok && !fl.undef {
+			return nil, fmt.Errorf("line %d: undefined variable %s",
+				bytes.Count(data[:i], []byte("\n"))+1, name)
+		}
+		out.Write(data[p:i])
+		if ok {
+			out.WriteString(v)
+		} else {
+			out.Write(data[i : i+2+j+1])
+		}
+		p = i + 2 + j + 1
+	}
+	out.Write(data[p:])
+	return out.Bytes(), nil
+}
+
+// readManifest reads the variables in manifest file "fname". Every
+// line holds a NAME=VALUE definition (spaces around the name and the
+// value are ignored); blank lines, and lines starting with "#", are
+// ignored.
+func readManifest(fname string) (map[string]string, error) {
+	var vars map[string]string
+
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	vars = make(map[string]string)
+	for n, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimRight(l, "\r")
+		if strings.TrimSpace(l) == "" ||
+			strings.HasPrefix(strings.TrimSpace(l), "#") {
+			continue
+		}
+		name, v, err := parseDefine(l)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fname, n+1, err)
+		}
+		vars[name] = strings.TrimSpace(v)
+	}
+	return vars, nil
+}
+
+// parseDefine parses variable definition "s", of the form NAME=VALUE
+func parseDefine(s string) (name, value string, err error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return "", "", fmt.Errorf("bad definition %q: no \"=\"", s)
+	}
+	name, value = strings.TrimSpace(s[:i]), s[i+1:]
+	if !isVarName(name) {
+		return "", "", fmt.Errorf("bad variable name %q", name)
+	}
+	return name, value, nil
+}
+
+// defineFlag is the flag.Value of the "-D" flag, which can be given
+// multiple times.
+type defineFlag struct{}
+
+func (df defineFlag) String() string {
+	return ""
+}
+
+func (df defineFlag) Set(value string) error {
+	name, v, err := parseDefine(value)
+	if err != nil {
+		return err
+	}
+	if fl.defines == nil {
+		fl.defines = make(map[string]string)
+	}
+	fl.defines[name] = v
+	return nil
+}
@@ -0,0 +1,170 @@
+// Include / exclude rules (-exclude, -include, .bundleignore files)
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/npat-efault/bundle"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A rule excludes (or, if negated, re-includes) the files and
+// directories matching a pattern. Rules follow the syntax and
+// semantics of .gitignore lines.
+type rule struct {
+	pat  string // Pattern, relative to base
+	base string // Directory the rule applies to ("" for all)
+	neg  bool   // Negated ("!pattern"), re-includes
+	dir  bool   // Matches only directories ("pattern/")
+}
+
+// parseRule parses rule "line", which applies to the files under
+// directory "base" (slash-separated, relative to the bundle root, or
+// empty). Returns false if the line is blank or a comment.
+func parseRule(line, base string) (rule, bool, error) {
+	var r rule
+
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return r, false, nil
+	}
+	if line[0] == '!' {
+		r.neg = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		// Escaped leading "!" or "#"
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dir = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return r, false, fmt.Errorf("bad rule: %q", line)
+	}
+	if strings.Contains(line, "/") {
+		// Anchored to base
+		r.pat = strings.TrimPrefix(line, "/")
+	} else {
+		// Matches at any depth
+		r.pat = "**/" + line
+	}
+	if strings.HasSuffix(r.pat, "/**") {
+		// Everything inside, but not the directory itself
+		r.pat += "/*"
+	}
+	r.base = base
+	if _, err := bundle.Match(r.pat, ""); err != nil {
+		return r, false, fmt.Errorf("bad rule %q: %s", line, err)
+	}
+	return r, true, nil
+}
+
+// match reports if rule "r" matches "name" (slash-separated,
+// relative to the bundle root). Argument "isDir" tells if name is a
+// directory.
+func (r *rule) match(name string, isDir bool) bool {
+	if r.dir && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(name, r.base+"/") {
+			return false
+		}
+		name = name[len(r.base)+1:]
+	}
+	ok, _ := bundle.Match(r.pat, name)
+	return ok
+}
+
+// excluded reports if "name" is excluded by "rules". The last rule
+// that matches the name decides.
+func excluded(rules []rule, name string, isDir bool) bool {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].match(name, isDir) {
+			return !rules[i].neg
+		}
+	}
+	return false
+}
+
+// flagRules returns the rules given by the "-exclude", "-include" and
+// "-skip" flags, in the order given.
+func flagRules() ([]rule, error) {
+	var rules []rule
+
+	for _, l := range fl.rules {
+		r, ok, err := parseRule(l, "")
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// readRules reads the rules in ignore file "fname", which apply to
+// the files under directory "base". Returns no rules (and no error)
+// if the file does not exist.
+func readRules(fname, base string) ([]rule, error) {
+	var rules []rule
+
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		r, ok, err := parseRule(s.Text(), base)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fname, n, err)
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, s.Err()
+}
+
+// ruleFlag is the flag.Value for the "-exclude", "-include", and
+// "-skip" flags. The patterns given are added, in order, to
+// fl.rules. Patterns given with "-include" are negated.
+type ruleFlag struct {
+	neg bool
+}
+
+func (rf ruleFlag) String() string {
+	return ""
+}
+
+func (rf ruleFlag) Set(value string) error {
+	if rf.neg {
+		value = "!" + value
+	}
+	fl.rules = append(fl.rules, value)
+	return nil
+}
+
+// dirRules returns the rules for the entries of directory "p" (named
+// "name" in the bundle), given the rules for its parent. These are
+// the parent rules, followed by the rules in the directory's ignore
+// file (see flag "-ignorefile"), if any.
+func dirRules(parent []rule, p, name string) ([]rule, error) {
+	if fl.ignore == "" {
+		return parent, nil
+	}
+	rules, err := readRules(filepath.Join(p, fl.ignore), name)
+	if err != nil || len(rules) == 0 {
+		return parent, err
+	}
+	// Do not modify the parent's rules
+	return append(parent[:len(parent):len(parent)], rules...), nil
+}
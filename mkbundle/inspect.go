@@ -0,0 +1,715 @@
+// mkbundle ls, cat and extract subcommands
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/npat-efault/bundle"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseBundle parses the Go source file "fname", generated by
+// mkbundle, and returns the index of the bundle defined in it. The
+// bundle is located by looking for []bundle.Entry composite literals
+// (or, for bundles generated with "-reserve", for a byte array
+// holding a reserved region). For bundles generated with "-split",
+// the part files are parsed as well. The entries are decoded by
+// package bundle, as they would be by the program the file is
+// compiled in.
+func parseBundle(fname string) (bundle.Index, error) {
+	var parts []string
+	var consts map[string]ast.Expr
+	var entries []bundle.Entry
+	var region []byte
+	var found bool
+	var err error
+
+	// Constants declared in the main file (e.g. the compression
+	// dictionary) are referenced by the part files.
+	consts = make(map[string]ast.Expr)
+	entries, region, found, err = parseFile(fname, consts)
+	if err != nil {
+		return nil, err
+	}
+	if region != nil {
+		return bundle.LoadRegion(region)
+	}
+	parts, err = partFiles(fname)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range parts {
+		pe, _, _, err := parseFile(fn, consts)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, pe...)
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: no bundle found", fname)
+	}
+	return bundle.MkIndex(entries), nil
+}
+
+// parseFile parses a single Go source file generated by mkbundle,
+// and returns the bundle entries, or the reserved region, found in
+// it. The top-level constants and variables of the file are added to
+// "consts", which may hold ones declared in other files as well.
+func parseFile(fname string,
+	consts map[string]ast.Expr) ([]bundle.Entry, []byte, bool, error) {
+	var fset *token.FileSet
+	var f *ast.File
+	var pkg string
+	var chunks map[string][]*bundle.Chunk
+	var entries []bundle.Entry
+	var region []byte
+	var found bool
+	var err error
+
+	fset = token.NewFileSet()
+	f, err = parser.ParseFile(fset, fname, nil, 0)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	// Name under which package bundle is imported
+	for _, imp := range f.Imports {
+		p, _ := strconv.Unquote(imp.Path.Value)
+		if p != fl.imp {
+			continue
+		}
+		pkg = "bundle"
+		if imp.Name != nil {
+			pkg = imp.Name.Name
+		}
+	}
+	if pkg == "" {
+		return nil, nil, false, fmt.Errorf("%s: package %s not imported",
+			fname, fl.imp)
+	}
+	// Top-level constant and variable initializers
+	for _, d := range f.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, sp := range gd.Specs {
+			vs, ok := sp.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			for i, nm := range vs.Names {
+				if i < len(vs.Values) {
+					consts[nm.Name] = vs.Values[i]
+				}
+			}
+		}
+	}
+	for _, v := range consts {
+		cl, ok := v.(*ast.CompositeLit)
+		if !ok {
+			continue
+		}
+		if sz, ok := isByteArray(cl.Type); ok {
+			r, err := parseRegion(cl, sz)
+			if err != nil {
+				return nil, nil, false,
+					fmt.Errorf("%s: %s", fname, err)
+			}
+			if len(r) >= len(bundle.RegionMagic) &&
+				string(r[:len(bundle.RegionMagic)]) ==
+					bundle.RegionMagic {
+				region = r
+				found = true
+			}
+		}
+	}
+	// Entry slices, in variable initializers, or in the init
+	// functions of part files
+	chunks = make(map[string][]*bundle.Chunk)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		cl, ok := n.(*ast.CompositeLit)
+		if !ok || !isEntrySlice(cl.Type, pkg) {
+			return true
+		}
+		for _, el := range cl.Elts {
+			e, err1 := parseEntry(el, pkg, consts, chunks)
+			if err1 != nil {
+				err = fmt.Errorf("%s: %s", fset.Position(el.Pos()),
+					err1)
+				return false
+			}
+			entries = append(entries, e)
+		}
+		found = true
+		return false
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return entries, region, found, nil
+}
+
+// isEntrySlice reports if type expression "t" is []<pkg>.Entry (or
+// an array of <pkg>.Entry, as generated by "-lazy")
+func isEntrySlice(t ast.Expr, pkg string) bool {
+	at, ok := t.(*ast.ArrayType)
+	if !ok {
+		return false
+	}
+	se, ok := at.Elt.(*ast.SelectorExpr)
+	if !ok || se.Sel.Name != "Entry" {
+		return false
+	}
+	x, ok := se.X.(*ast.Ident)
+	return ok && x.Name == pkg
+}
+
+// isByteArray reports if type expression "t" is [N]byte, and returns
+// N.
+func isByteArray(t ast.Expr) (int, bool) {
+	at, ok := t.(*ast.ArrayType)
+	if !ok || at.Len == nil {
+		return 0, false
+	}
+	if el, ok := at.Elt.(*ast.Ident); !ok || el.Name != "byte" {
+		return 0, false
+	}
+	n, err := intLit(at.Len)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseEntry converts the composite literal of a bundle entry to an
+// Entry. String constants (and chunk arrays) referenced by the
+// literal are looked up in "consts". Chunk arrays are parsed once,
+// and kept in "chunks", so that entries in the same chunk share it.
+func parseEntry(el ast.Expr, pkg string, consts map[string]ast.Expr,
+	chunks map[string][]*bundle.Chunk) (bundle.Entry, error) {
+	var e bundle.Entry
+	var err error
+
+	cl, ok := el.(*ast.CompositeLit)
+	if !ok {
+		return e, errors.New("entry is not a composite literal")
+	}
+	for _, f := range cl.Elts {
+		kv, ok := f.(*ast.KeyValueExpr)
+		if !ok {
+			return e, errors.New("entry field without key")
+		}
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			return e, errors.New("bad entry field key")
+		}
+		switch key.Name {
+		case "Name":
+			e.Name, err = stringExpr(kv.Value, consts)
+		case "Size":
+			e.Size, err = intLit(kv.Value)
+		case "Gzip":
+			e.Gzip, err = boolLit(kv.Value)
+		case "Dict":
+			e.Dict, err = stringExpr(kv.Value, consts)
+		case "Data":
+			e.Data, err = stringExpr(kv.Value, consts)
+		case "Raw":
+			e.Raw, err = stringExpr(kv.Value, consts)
+		case "Sum":
+			e.Sum, err = stringExpr(kv.Value, consts)
+		case "Link":
+			e.Link, err = stringExpr(kv.Value, consts)
+		case "Mode":
+			var m int
+			m, err = intLit(kv.Value)
+			e.Mode = fs.FileMode(m)
+		case "Chunk":
+			e.Chunk, err = chunkRef(kv.Value, pkg, consts, chunks)
+		case "Off":
+			e.Off, err = intLit(kv.Value)
+		default:
+			err = fmt.Errorf("unknown entry field %s", key.Name)
+		}
+		if err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// chunkRef returns the chunk referenced by expression "x" (of the
+// form &<array>[<index>]), parsing the array if required.
+func chunkRef(x ast.Expr, pkg string, consts map[string]ast.Expr,
+	chunks map[string][]*bundle.Chunk) (*bundle.Chunk, error) {
+	ux, ok := x.(*ast.UnaryExpr)
+	if !ok || ux.Op != token.AND {
+		return nil, errors.New("bad chunk reference")
+	}
+	ix, ok := ux.X.(*ast.IndexExpr)
+	if !ok {
+		return nil, errors.New("bad chunk reference")
+	}
+	id, ok := ix.X.(*ast.Ident)
+	if !ok {
+		return nil, errors.New("bad chunk reference")
+	}
+	i, err := intLit(ix.Index)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := chunks[id.Name]
+	if !ok {
+		l, err = parseChunks(id.Name, pkg, consts)
+		if err != nil {
+			return nil, err
+		}
+		chunks[id.Name] = l
+	}
+	if i < 0 || i >= len(l) {
+		return nil, fmt.Errorf("chunk index out of range: %d", i)
+	}
+	return l[i], nil
+}
+
+// parseChunks parses the array of chunks "name" (looked up in
+// "consts").
+func parseChunks(name, pkg string,
+	consts map[string]ast.Expr) ([]*bundle.Chunk, error) {
+	var l []*bundle.Chunk
+	var err error
+
+	cl, ok := consts[name].(*ast.CompositeLit)
+	if !ok {
+		return nil, fmt.Errorf("undefined: %s", name)
+	}
+	at, ok := cl.Type.(*ast.ArrayType)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a chunk array", name)
+	}
+	se, ok := at.Elt.(*ast.SelectorExpr)
+	if !ok || se.Sel.Name != "Chunk" {
+		return nil, fmt.Errorf("%s: not a chunk array", name)
+	}
+	if x, ok := se.X.(*ast.Ident); !ok || x.Name != pkg {
+		return nil, fmt.Errorf("%s: not a chunk array", name)
+	}
+	for _, el := range cl.Elts {
+		var c bundle.Chunk
+
+		ccl, ok := el.(*ast.CompositeLit)
+		if !ok {
+			return nil, errors.New("chunk is not a composite literal")
+		}
+		for _, f := range ccl.Elts {
+			kv, ok := f.(*ast.KeyValueExpr)
+			if !ok {
+				return nil, errors.New("chunk field without key")
+			}
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				return nil, errors.New("bad chunk field key")
+			}
+			switch key.Name {
+			case "Size":
+				c.Size, err = intLit(kv.Value)
+			case "Data":
+				c.Data, err = stringExpr(kv.Value, consts)
+			case "Raw":
+				c.Raw, err = stringExpr(kv.Value, consts)
+			default:
+				err = fmt.Errorf("unknown chunk field %s",
+					key.Name)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		l = append(l, &c)
+	}
+	return l, nil
+}
+
+// stringExpr evaluates a (constant) string expression: A string
+// literal, a concatenation of string expressions, a reference to a
+// constant (looked up in "consts"), or a slice of a string
+// expression with constant indices.
+func stringExpr(x ast.Expr, consts map[string]ast.Expr) (string, error) {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		if x.Kind != token.STRING {
+			break
+		}
+		return strconv.Unquote(x.Value)
+	case *ast.ParenExpr:
+		return stringExpr(x.X, consts)
+	case *ast.BinaryExpr:
+		if x.Op != token.ADD {
+			break
+		}
+		// Walk the (left-nested) chain of concatenations
+		// iteratively, as it may be very long.
+		var ops []ast.Expr
+		var y ast.Expr = x
+		for {
+			b, ok := y.(*ast.BinaryExpr)
+			if !ok || b.Op != token.ADD {
+				break
+			}
+			ops = append(ops, b.Y)
+			y = b.X
+		}
+		ops = append(ops, y)
+		var sb strings.Builder
+		for i := len(ops) - 1; i >= 0; i-- {
+			s, err := stringExpr(ops[i], consts)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s)
+		}
+		return sb.String(), nil
+	case *ast.Ident:
+		v, ok := consts[x.Name]
+		if !ok {
+			return "", fmt.Errorf("undefined: %s", x.Name)
+		}
+		// Avoid evaluating the same (large) constant again
+		s, err := stringExpr(v, consts)
+		if err != nil {
+			return "", err
+		}
+		consts[x.Name] = &ast.BasicLit{Kind: token.STRING,
+			Value: strconv.Quote(s)}
+		return s, nil
+	case *ast.SliceExpr:
+		s, err := stringExpr(x.X, consts)
+		if err != nil {
+			return "", err
+		}
+		lo, hi := 0, len(s)
+		if x.Low != nil {
+			if lo, err = intLit(x.Low); err != nil {
+				return "", err
+			}
+		}
+		if x.High != nil {
+			if hi, err = intLit(x.High); err != nil {
+				return "", err
+			}
+		}
+		if lo < 0 || hi < lo || hi > len(s) {
+			return "", errors.New("slice bounds out of range")
+		}
+		return s[lo:hi], nil
+	}
+	return "", errors.New("not a constant string expression")
+}
+
+// intLit returns the value of an integer literal
+func intLit(x ast.Expr) (int, error) {
+	bl, ok := x.(*ast.BasicLit)
+	if !ok || bl.Kind != token.INT {
+		return 0, errors.New("not an integer literal")
+	}
+	n, err := strconv.ParseInt(bl.Value, 0, 0)
+	return int(n), err
+}
+
+// boolLit returns the value of a boolean constant
+func boolLit(x ast.Expr) (bool, error) {
+	id, ok := x.(*ast.Ident)
+	if ok && id.Name == "true" {
+		return true, nil
+	}
+	if ok && id.Name == "false" {
+		return false, nil
+	}
+	return false, errors.New("not a boolean constant")
+}
+
+// parseRegion returns the contents of the byte array literal "cl",
+// of size "sz"
+func parseRegion(cl *ast.CompositeLit, sz int) ([]byte, error) {
+	var r []byte
+
+	if len(cl.Elts) > sz {
+		return nil, errors.New("too many array elements")
+	}
+	r = make([]byte, sz)
+	for i, el := range cl.Elts {
+		n, err := intLit(el)
+		if err != nil || n < 0 || n > 0xff {
+			return nil, errors.New("bad byte array element")
+		}
+		r[i] = byte(n)
+	}
+	return r, nil
+}
+
+// allEntries returns all the entries of "idx", sorted by name. Unlike
+// Index.Dir, it includes link and directory entries.
+func allEntries(idx bundle.Index) []*bundle.Entry {
+	var l bundle.Dir
+
+	for _, e := range idx {
+		l = append(l, e)
+	}
+	sort.Sort(l)
+	return l
+}
+
+// cmdLs implements:
+//
+//	mkbundle ls <bundle.go>
+//
+// It lists the entries of the bundle: size, compression, and name.
+func cmdLs(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: ls <bundle.go>")
+	}
+	idx, err := parseBundle(args[0])
+	if err != nil {
+		return err
+	}
+	for _, e := range allEntries(idx) {
+		if e.Link != "" {
+			fmt.Printf("%10d l %s -> %s\n", e.Size, e.Name, e.Link)
+			continue
+		}
+		if e.IsDir() {
+			fmt.Printf("%10d d %s/ (%s)\n", e.Size, e.Name, e.Mode)
+			continue
+		}
+		z := "-"
+		if e.Gzip {
+			z = "z"
+		} else if e.Chunk != nil {
+			z = "c"
+		}
+		fmt.Printf("%10d %s %s\n", e.Size, z, e.Name)
+	}
+	return nil
+}
+
+// cmdCat implements:
+//
+//	mkbundle cat <bundle.go> <name>...
+//
+// It writes the data of the named entries to <stdout>.
+func cmdCat(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: cat <bundle.go> <name>...")
+	}
+	idx, err := parseBundle(args[0])
+	if err != nil {
+		return err
+	}
+	for _, nm := range args[1:] {
+		e := idx.Entry(nm)
+		if e == nil {
+			return fmt.Errorf("%s: entry not found", nm)
+		}
+		data, err := e.Decode(0)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Maximum number of links extractTarget follows, like the file
+// system does when resolving a name
+const extractMaxLinks = 40
+
+// Errors returned by extractTarget
+var (
+	errOutside  = errors.New("outside the bundle")
+	errLinkLoop = errors.New("too many links")
+)
+
+// extractTarget returns the name (relative to the extraction
+// directory) that link "link", in directory "dir", points to once
+// extracted. The links along the way are resolved like the file
+// system resolves them: ".." after a link applies to the link's
+// target, not to the link's directory. It returns errOutside if the
+// target is outside the extraction directory, and errLinkLoop if
+// more links than the file system would follow are encountered. The
+// number of links followed so far is kept in "n".
+func extractTarget(idx bundle.Index, dir, link string,
+	n *int) (string, error) {
+	var l []string
+
+	if path.IsAbs(link) {
+		return "", errOutside
+	}
+	if dir != "." {
+		l = strings.Split(dir, "/")
+	}
+	for _, c := range strings.Split(link, "/") {
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(l) == 0 {
+				return "", errOutside
+			}
+			l = l[:len(l)-1]
+			continue
+		}
+		l = append(l, c)
+		nm := strings.Join(l, "/")
+		e := idx[nm]
+		if e == nil || e.Link == "" {
+			continue
+		}
+		if *n++; *n > extractMaxLinks {
+			return "", errLinkLoop
+		}
+		t, err := extractTarget(idx, path.Dir(nm), e.Link, n)
+		if err != nil {
+			return "", err
+		}
+		l = nil
+		if t != "" {
+			l = strings.Split(t, "/")
+		}
+	}
+	return strings.Join(l, "/"), nil
+}
+
+// checkExtract returns an error if entry "e" of "idx" cannot be
+// extracted safely: If its name is not local, if it is under a link
+// entry (it would be written through the link), or if it is a link
+// pointing outside the bundle, once extracted. Links that cannot be
+// resolved (e.g. because of a cycle) are allowed, since the file
+// system does not follow them either.
+func checkExtract(idx bundle.Index, e *bundle.Entry) error {
+	var n int
+
+	if !filepath.IsLocal(e.Name) {
+		return fmt.Errorf("%s: bad entry name", e.Name)
+	}
+	name := path.Clean(e.Name)
+	for d := path.Dir(name); d != "."; d = path.Dir(d) {
+		if l := idx[d]; l != nil && l.Link != "" {
+			return fmt.Errorf("%s: under link %s", e.Name, d)
+		}
+	}
+	if e.Link == "" {
+		return nil
+	}
+	_, err := extractTarget(idx, path.Dir(name), e.Link, &n)
+	if err == errOutside {
+		return fmt.Errorf("%s: link to %s, outside the bundle",
+			e.Name, e.Link)
+	}
+	return nil
+}
+
+// cmdExtract implements:
+//
+//	mkbundle extract <bundle.go> <dir>
+//
+// It writes the data of all the entries in the bundle to files under
+// <dir>, creating sub-directories as required. Link entries are
+// extracted as symbolic links, after all files, so that no files are
+// written through them. Bundles with entries that cannot be
+// extracted safely (see checkExtract) are refused, before anything
+// is written. Directory entries are extracted as (possibly empty)
+// directories, with their recorded modes.
+func cmdExtract(args []string) error {
+	var links, dirs []*bundle.Entry
+
+	if len(args) != 2 {
+		return errors.New("usage: extract <bundle.go> <dir>")
+	}
+	idx, err := parseBundle(args[0])
+	if err != nil {
+		return err
+	}
+	for _, e := range allEntries(idx) {
+		if err = checkExtract(idx, e); err != nil {
+			return err
+		}
+	}
+	for _, e := range allEntries(idx) {
+		if e.Link != "" {
+			links = append(links, e)
+			continue
+		}
+		fn := filepath.Join(args[1], filepath.FromSlash(e.Name))
+		if e.IsDir() {
+			err = os.MkdirAll(fn, 0755)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, e)
+			continue
+		}
+		data, err := e.Decode(0)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(fn), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(fn, data, 0644)
+		if err != nil {
+			return err
+		}
+		if fl.verbose {
+			log.Printf("+ %s", e.Name)
+		}
+	}
+	for _, e := range links {
+		fn := filepath.Join(args[1], filepath.FromSlash(e.Name))
+		err = os.MkdirAll(filepath.Dir(fn), 0755)
+		if err != nil {
+			return err
+		}
+		// Replace existing file or link
+		os.Remove(fn)
+		err = os.Symlink(filepath.FromSlash(e.Link), fn)
+		if err != nil {
+			return err
+		}
+		if fl.verbose {
+			log.Printf("+ %s -> %s", e.Name, e.Link)
+		}
+	}
+	// Directory modes are set last, since they may not allow
+	// writing
+	for _, e := range dirs {
+		fn := filepath.Join(args[1], filepath.FromSlash(e.Name))
+		err = os.Chmod(fn, e.Mode.Perm())
+		if err != nil {
+			return err
+		}
+		if fl.verbose {
+			log.Printf("+ %s/ (%s)", e.Name, e.Mode)
+		}
+	}
+	return nil
+}
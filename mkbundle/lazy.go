@@ -0,0 +1,62 @@
+// Separate entry variables and lazily built index (-lazy)
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// emitLazy emits the bundle entries for "files" as separate
+// variables (one-element arrays), and the index as a function that
+// builds it on its first call. Entries not referenced by the program
+// (except through the index function) are dropped by the linker.
+func emitLazy(w io.Writer, files []srcFile) error {
+	var vars map[string]string
+	var err error
+
+	_, err = fmt.Fprintf(w, LazyHeadFormat,
+		fl.pkg, fl.bundle, fl.index,
+		importSpec(), generated())
+	if err != nil {
+		return err
+	}
+	_, vars = mkNames(fl.bundle+"_", files, false)
+	for _, f := range files {
+		_, err = fmt.Fprintf(w, LazyEntryHeadFormat, vars[f.name])
+		if err != nil {
+			return err
+		}
+		err = emitEntries(w, []srcFile{f})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, LazyEntryFootFormat)
+		if err != nil {
+			return err
+		}
+	}
+	err = emitDict(w)
+	if err != nil {
+		return err
+	}
+	err = emitShared(w, shared)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, LazyIndexHeadFormat, fl.index)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		_, err = fmt.Fprintf(w, LazyIndexEntryFormat, vars[f.name])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, LazyIndexFootFormat)
+	if err != nil {
+		return err
+	}
+	return emitBundleEnd(w, files)
+}
@@ -3,46 +3,135 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"go/format"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// generated returns the "Generated: <time>" line of the header of
+// generated files, or an empty string if "-stable" is given.
+func generated() string {
+	if fl.stable {
+		return ""
+	}
+	return fmt.Sprintf(GeneratedFormat, time.Now().Format(time.RFC3339))
+}
+
+// importSpec returns the import spec for package bundle, in
+// generated files. Package bundle is imported from the path given by
+// the "-import" flag, and it is always referred to as "bundle".
+func importSpec() string {
+	if path.Base(fl.imp) == "bundle" {
+		return strconv.Quote(fl.imp)
+	}
+	return "bundle " + strconv.Quote(fl.imp)
+}
+
+// emitSource calls "emit" to generate Go source code, formats the
+// generated code with go/format, and writes it to "w". Returns an
+// error if the generated code cannot be parsed.
+func emitSource(w io.Writer, emit func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	var src []byte
+	var err error
+
+	err = emit(&buf)
+	if err != nil {
+		return err
+	}
+	src, err = format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("bad generated code: %s", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
 func emitBundleHeader(w io.Writer, pkg, bundle, index string) error {
 	var err error
 	_, err = fmt.Fprintf(w, BundleHeadFormat,
 		pkg, bundle, index,
-		BundleImportPath,
-		time.Now().Format(time.RFC3339))
+		importSpec(),
+		generated())
 	return err
 }
 
 func emitBundleFooter(w io.Writer, bundle, index string) error {
 	var err error
 	_, err = fmt.Fprintf(w, BundleFootFormat, bundle, index)
+	if err != nil {
+		return err
+	}
+	err = emitChunks(w)
+	if err != nil {
+		return err
+	}
+	if blob != nil {
+		err = emitBlob(w, blob)
+		if err != nil {
+			return err
+		}
+	}
+	err = emitDict(w)
+	if err != nil {
+		return err
+	}
+	return emitShared(w, shared)
+}
+
+// emitBundleEnd emits the entry names (or accessors) for "files", if
+// requested by the "-names" flag, and the end-of-bundle marker.
+func emitBundleEnd(w io.Writer, files []srcFile) error {
+	var err error
+
+	if fl.names != "" {
+		err = emitNames(w, files)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, BundleEndFormat)
 	return err
 }
 
-func emitFile(w io.Writer, fpath, name string, sz int, zip bool) error {
-	var f *os.File
+// Data of all entries, for the "blob" layout. Nil for other layouts.
+var blob *Blob
+
+// Entries of the bundle container, for the "bin" format. Nil for
+// other formats.
+var bin *Container
+
+func emitFile(w io.Writer, sf srcFile, zip bool) error {
+	var f io.ReadCloser
 	var gw io.WriteCloser
 	var err error
 
-	f, err = os.Open(fpath)
+	f, err = sf.open()
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if zip {
-		gw, err = NewGoZipWriter(w, name, sz)
+	if bin != nil {
+		return bin.Add(f, sf.name, zip)
+	}
+	if blob != nil {
+		gw, err = NewBlobWriter(w, blob, sf.name, sf.size, zip)
+	} else if zip {
+		gw, err = NewGoZipWriter(w, sf.name, sf.size)
 	} else {
-		gw, err = NewGoWriter(w, name, sz)
+		gw, err = NewGoWriter(w, sf.name, sf.size)
 	}
 	if err != nil {
 		return err
@@ -53,90 +142,302 @@ func emitFile(w io.Writer, fpath, name string, sz int, zip bool) error {
 	return err
 }
 
-func walkDir(w io.Writer, fpath string) error {
-	// Walk directory
-	var wf = func(p string, i os.FileInfo, e error) error {
-		var nm string
-		var err error
-		var ok bool
+// A srcFile is a file to be included in the bundle
+type srcFile struct {
+	path string // Path of the file
+	name string // Name of the entry
+	size int
+	link string // Link target, for link entries (-symlinks=link)
+	data []byte // Filtered contents (see -filter), or nil
+	mode os.FileMode
+}
 
-		if e != nil {
-			return e
+// open opens the file for reading its contents (as filtered, if
+// filters apply to it).
+func (f srcFile) open() (io.ReadCloser, error) {
+	if f.data != nil {
+		return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+	}
+	return os.Open(f.path)
+}
+
+// A walker collects the files to be included in the bundle, by
+// walking a directory tree.
+type walker struct {
+	files []srcFile
+	real  []string // Real paths of the directories being walked
+}
+
+func walkDir(fpath string) ([]srcFile, error) {
+	var wk walker
+	var rules []rule
+	var err error
+
+	rules, err = flagRules()
+	if err != nil {
+		return nil, err
+	}
+	rules, err = dirRules(rules, fpath, "")
+	if err != nil {
+		return nil, err
+	}
+	err = wk.walk(fpath, "", rules)
+	if err != nil {
+		return nil, err
+	}
+	return wk.files, nil
+}
+
+// walk walks the subtree rooted at directory "p", named "name" in the
+// bundle ("" for the root). The include / exclude rules for the
+// entries of the directory are "rules". Symbolic links are handled
+// as selected by the "-symlinks" flag.
+func (wk *walker) walk(p, name string, rules []rule) error {
+	var l []os.FileInfo
+	var err error
+
+	if fl.links == "follow" {
+		// Detect cycles
+		real, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return err
+		}
+		for _, r := range wk.real {
+			if r == real {
+				log.Printf("%s: symlink cycle, skipped", p)
+				return nil
+			}
 		}
-		// Handle skip-patterns
-		for _, pat := range fl.skip {
-			ok, _ = filepath.Match(pat, i.Name())
-			if ok {
-				if i.IsDir() {
-					return filepath.SkipDir
-				} else {
-					return nil
+		wk.real = append(wk.real, real)
+		defer func() { wk.real = wk.real[:len(wk.real)-1] }()
+	}
+	l, err = ioutil.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, i := range l {
+		fp := filepath.Join(p, i.Name())
+		nm := path.Join(name, i.Name())
+		if i.Mode()&os.ModeSymlink != 0 {
+			switch fl.links {
+			case "follow":
+				i, err = os.Stat(fp)
+				if err != nil {
+					log.Printf("%s: bad symlink, skipped: %s",
+						fp, err)
+					continue
 				}
+			case "link":
+				if excluded(rules, nm, false) {
+					continue
+				}
+				err = wk.addLink(fp, nm)
+				if err != nil {
+					return err
+				}
+				continue
+			default:
+				log.Printf("%s: skipped symlink", fp)
+				continue
 			}
 		}
+		// Handle include / exclude rules
+		if excluded(rules, nm, i.IsDir()) {
+			continue
+		}
 		if i.IsDir() {
-			// continue
-			return nil
-		} else if i.Mode().IsRegular() {
-			nm, err = filepath.Rel(fpath, p)
+			if fl.dirs {
+				wk.files = append(wk.files, srcFile{path: fp,
+					name: filepath.FromSlash(nm),
+					mode: i.Mode()})
+			}
+			dr, err := dirRules(rules, fp, nm)
 			if err != nil {
 				return err
 			}
-			if fl.verbose {
-				log.Printf("+ %s", nm)
+			err = wk.walk(fp, nm, dr)
+			if err != nil {
+				return err
 			}
-			return emitFile(w, p, nm,
-				int(i.Size()), fl.gzip)
+		} else if i.Name() == fl.ignore {
+			continue
+		} else if i.Mode().IsRegular() {
+			wk.files = append(wk.files, srcFile{path: fp,
+				name: filepath.FromSlash(nm), size: int(i.Size())})
 		} else {
-			log.Printf("%s: skipped non-regular file", p)
-			return nil
+			log.Printf("%s: skipped non-regular file", fp)
 		}
 	}
-	return filepath.Walk(fpath, wf)
+	return nil
 }
 
-func emitBundle(w io.Writer, fpath string) error {
-	var info os.FileInfo
-	var err error
-
-	err = emitBundleHeader(w, fl.pkg, fl.bundle, fl.index)
+// addLink adds a link entry, named "name", for symbolic link "p".
+func (wk *walker) addLink(p, name string) error {
+	t, err := os.Readlink(p)
 	if err != nil {
 		return err
 	}
+	t = filepath.ToSlash(t)
+	if tp := path.Join(path.Dir(name), t); path.IsAbs(t) ||
+		tp == ".." || strings.HasPrefix(tp, "../") {
+		log.Printf("%s: link to %s, outside the bundle", p, t)
+	}
+	wk.files = append(wk.files, srcFile{path: p,
+		name: filepath.FromSlash(name), link: t})
+	return nil
+}
+
+// collectFiles returns the files to be included in the bundle
+// generated from "fpath"
+func collectFiles(fpath string) ([]srcFile, error) {
+	var info os.FileInfo
+	var files []srcFile
+	var err error
 
-	info, err = os.Lstat(fpath)
+	if fl.links == "follow" {
+		info, err = os.Stat(fpath)
+	} else {
+		info, err = os.Lstat(fpath)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if info.Mode().IsRegular() {
-		// Emit signle file
+		// Signle file
 		name := path.Base(fpath)
-		err = emitFile(w, fpath, name, int(info.Size()),
-			fl.gzip)
-		if err != nil {
-			return err
-		}
+		files = []srcFile{{path: fpath, name: name,
+			size: int(info.Size())}}
 	} else if info.Mode().IsDir() {
 		// Walk subtree rooted at dir
-		err = walkDir(w, fpath)
+		files, err = walkDir(fpath)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		// Oops!
 		err = fmt.Errorf("%s: not a regular file or directory",
 			fpath)
+		return nil, err
+	}
+	if fl.stable {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].name < files[j].name
+		})
+	}
+	err = filterFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// emitEntry emits the bundle entry for "f"
+func emitEntry(w io.Writer, f srcFile) error {
+	var err error
+
+	switch {
+	case f.mode.IsDir():
+		if bin != nil {
+			bin.AddDir(f.name, f.mode)
+			return nil
+		}
+		_, err = fmt.Fprintf(w, DirFormat,
+			f.name, uint32(f.mode), f.mode)
+	case f.link != "":
+		if bin != nil {
+			bin.AddLink(f.name, f.link)
+			return nil
+		}
+		_, err = fmt.Fprintf(w, LinkFormat, f.name, f.link)
+	default:
+		var done bool
+		done, err = shared.emitFile(w, f)
+		if !done {
+			err = emitFile(w, f, fl.gzip)
+		}
+	}
+	return err
+}
+
+// emitEntries emits the bundle entries for "files"
+func emitEntries(w io.Writer, files []srcFile) error {
+	for _, f := range files {
+		if fl.verbose {
+			log.Printf("+ %s", f.name)
+		}
+		err := emitEntry(w, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func emitBundle(w io.Writer, fpath string) error {
+	var files []srcFile
+	var err error
+
+	files, err = collectFiles(fpath)
+	if err != nil {
+		return err
+	}
+	if bin != nil {
+		err = emitEntries(w, files)
+		if err != nil {
+			return err
+		}
+		if fl.reserve > 0 {
+			err = emitRegion(w, bin, fl.reserve)
+			if err != nil {
+				return err
+			}
+			return emitBundleEnd(w, files)
+		}
+		_, err = bin.WriteTo(w)
+		return err
+	}
+	if fl.dict > 0 {
+		dict, err = mkDict(files, fl.dict)
+		if err != nil {
+			return err
+		}
+		defer func() { dict = nil }()
+		if fl.verbose {
+			log.Printf("Built %d bytes compression dictionary",
+				len(dict))
+		}
+	}
+	if fl.split != "" {
+		return emitSplit(w, files)
+	}
+	shared, err = newShared(files, fl.bundle+"Data_")
+	if err != nil {
 		return err
 	}
+	defer func() { shared = nil }()
+	if fl.lazy {
+		return emitLazy(w, files)
+	}
+	if fl.solid > 0 {
+		return emitSolid(w, files)
+	}
 
+	err = emitBundleHeader(w, fl.pkg, fl.bundle, fl.index)
+	if err != nil {
+		return err
+	}
+	err = emitEntries(w, files)
+	if err != nil {
+		return err
+	}
 	err = emitBundleFooter(w, fl.bundle, fl.index)
 	if err != nil {
 		return err
 	}
-	return nil
+	return emitBundleEnd(w, files)
 }
 
-func isYounger(ofn string, ifn string) bool {
+func isYounger(ofn string, ifn ...string) bool {
 	var oinf os.FileInfo
 	var err error
 
@@ -158,9 +459,14 @@ func isYounger(ofn string, ifn string) bool {
 		}
 		return nil
 	}
-	err = filepath.Walk(ifn, wf)
-	if err != nil {
-		return false
+	for _, fn := range ifn {
+		if fn == "" {
+			continue
+		}
+		err = filepath.Walk(fn, wf)
+		if err != nil {
+			return false
+		}
 	}
 	return true
 }
@@ -169,6 +475,17 @@ func main() {
 	var fo *os.File
 	var err error
 
+	if len(os.Args) > 1 && commands[os.Args[1]] != nil {
+		// Subcommand
+		cmd := commands[os.Args[1]]
+		flag.CommandLine.Parse(os.Args[2:])
+		err = cmd(flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	flag.Parse()
 	if fl.help {
 		flag.CommandLine.SetOutput(os.Stdout)
@@ -183,7 +500,132 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
-	if !fl.always && isYounger(fl.out, flag.Arg(0)) {
+	switch fl.format {
+	case "go":
+		if fl.reserve > 0 {
+			bin = NewContainer()
+		}
+	case "bin":
+		bin = NewContainer()
+	default:
+		fmt.Fprintf(os.Stderr,
+			"invalid format: %s\n", fl.format)
+		flag.Usage()
+		os.Exit(1)
+	}
+	switch fl.layout {
+	case "base64":
+	case "blob":
+		blob = NewBlob(fl.bundle + "Blob")
+	default:
+		fmt.Fprintf(os.Stderr,
+			"invalid layout: %s\n", fl.layout)
+		flag.Usage()
+		os.Exit(1)
+	}
+	switch fl.split {
+	case "":
+	case "entry", "dir", "size", "pkg":
+		if fl.format != "go" || fl.reserve > 0 || fl.out == "" {
+			fmt.Fprintf(os.Stderr, "-split requires -out, "+
+				"and cannot be used with -format=bin "+
+				"or -reserve.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if fl.split == "size" && fl.maxsize <= 0 {
+			fmt.Fprintf(os.Stderr,
+				"-split=size requires -maxsize.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if fl.split == "pkg" && (fl.pkgpath == "" ||
+			strings.HasSuffix(fl.out, "_test.go")) {
+			fmt.Fprintf(os.Stderr, "-split=pkg requires "+
+				"-pkgpath, and cannot generate test files.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr,
+			"invalid split mode: %s\n", fl.split)
+		flag.Usage()
+		os.Exit(1)
+	}
+	switch fl.links {
+	case "skip", "follow", "link":
+	default:
+		fmt.Fprintf(os.Stderr,
+			"invalid symlinks mode: %s\n", fl.links)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if fl.lazy {
+		if fl.format != "go" || fl.reserve > 0 ||
+			fl.layout != "base64" || fl.split != "" {
+			fmt.Fprintf(os.Stderr, "-lazy cannot be used with "+
+				"-format=bin, -reserve, -layout=blob, "+
+				"or -split.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if fl.dict != 0 {
+		if fl.format != "go" || fl.reserve > 0 ||
+			fl.split == "pkg" || fl.dict < 0 ||
+			fl.dict > dictMaxSize {
+			fmt.Fprintf(os.Stderr, "-dict cannot be used with "+
+				"-format=bin, -reserve, or -split=pkg, and "+
+				"must be between 1 and %d.\n", dictMaxSize)
+			flag.Usage()
+			os.Exit(1)
+		}
+		// Dictionary compression replaces gzip
+		fl.gzip = true
+	}
+	if fl.solid > 0 {
+		if fl.format != "go" || fl.reserve > 0 || fl.lazy ||
+			fl.split != "" || fl.dict > 0 {
+			fmt.Fprintf(os.Stderr, "-solid cannot be used with "+
+				"-format=bin, -reserve, -lazy, -split, "+
+				"or -dict.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	switch fl.names {
+	case "":
+	case "const", "func":
+		if fl.format != "go" {
+			fmt.Fprintf(os.Stderr,
+				"-names cannot be used with -format=bin.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr,
+			"invalid names mode: %s\n", fl.names)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if fl.append {
+		if fl.format != "bin" || fl.out == "" {
+			fmt.Fprintf(os.Stderr,
+				"-append requires -format=bin and -out.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if fl.verbose {
+			log.Printf("Appending bundle to %s", fl.out)
+		}
+		err = appendBundle(fl.out, flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if !fl.always && !volatileInputs() &&
+		isYounger(fl.out, flag.Arg(0), fl.varfile) {
 		if fl.verbose {
 			log.Printf("%s is younger than %s",
 				fl.out, flag.Arg(0))
@@ -205,27 +647,41 @@ func main() {
 			log.Print("Generating on <stdout>")
 		}
 	}
-	err = emitBundle(fo, flag.Arg(0))
+	if bin == nil || fl.reserve > 0 {
+		err = emitSource(fo, func(w io.Writer) error {
+			return emitBundle(w, flag.Arg(0))
+		})
+	} else {
+		err = emitBundle(fo, flag.Arg(0))
+	}
 	if err != nil {
 		if fl.out != "" {
 			os.Remove(fl.out)
 		}
 		log.Fatal(err)
 	}
+	if fl.verbose && dedupSaved > 0 {
+		log.Printf("Identical contents emitted once "+
+			"(%d bytes of file data)", dedupSaved)
+	}
 }
 
-// Setup for command line arguments parsing
+// Subcommands. Each is called with the command-line arguments that
+// remain after parsing the flags following the subcommand name.
+var commands map[string]func(args []string) error
 
-type patlist []string
-
-func (pl *patlist) String() string {
-	return fmt.Sprint(*pl)
+func init() {
+	commands = map[string]func(args []string) error{
+		"replace":  cmdReplace,
+		"ls":       cmdLs,
+		"cat":      cmdCat,
+		"extract":  cmdExtract,
+		"diff":     cmdDiff,
+		"textconv": cmdTextconv,
+	}
 }
 
-func (pl *patlist) Set(value string) error {
-	*pl = append(*pl, value)
-	return nil
-}
+// Setup for command line arguments parsing
 
 var fl struct {
 	out     string
@@ -233,18 +689,68 @@ var fl struct {
 	bundle  string
 	index   string
 	gzip    bool
-	skip    patlist
+	format  string
+	layout  string
+	reserve int
+	stable  bool
+	split   string
+	maxsize int
+	pkgpath string
+	names   string
+	imp     string
+	lazy    bool
+	prefix  string
+	rules   []string
+	filters []string
+	defines map[string]string
+	varfile string
+	undef   bool
+	ignore  string
+	links   string
+	dirs    bool
+	dedup   bool
+	dict    int
+	solid   int
+	append  bool
 	always  bool
 	verbose bool
 	help    bool
 }
 
 func init() {
-	flag.Var(&fl.skip, "skip", "Files/dirs to skip (glob pattern)")
+	flag.Var(ruleFlag{}, "exclude",
+		"Exclude files/dirs (gitignore-style pattern)")
+	flag.Var(ruleFlag{neg: true}, "include",
+		"Re-include excluded files/dirs (pattern)")
+	flag.Var(ruleFlag{}, "skip", "Same as \"-exclude\"")
+	flag.Var(filterFlag{}, "filter",
+		"Filter files matching a pattern: <pattern>=<filter>")
+	flag.Var(defineFlag{}, "D",
+		"Define variable for the expand filter: NAME=VALUE")
+	flag.StringVar(&fl.varfile, "manifest", "",
+		"File with variables for the expand filter (NAME=VALUE lines)")
+	flag.BoolVar(&fl.undef, "allowundef", false,
+		"Leave undefined variables unexpanded (instead of failing)")
+	flag.StringVar(&fl.ignore, "ignorefile", ".bundleignore",
+		"Name of per-directory ignore files")
+	flag.BoolVar(&fl.dirs, "dirs", false,
+		"Record directory entries (with their modes)")
+	flag.BoolVar(&fl.dedup, "dedup", false,
+		"Emit the data of identical files once")
+	flag.IntVar(&fl.dict, "dict", 0,
+		"Compress with a shared dictionary of this size (bytes)")
+	flag.IntVar(&fl.solid, "solid", 0,
+		"Compress entries together, in chunks of this size (bytes)")
+	flag.StringVar(&fl.links, "symlinks", "skip",
+		"Symbolic links: \"skip\", \"follow\", or \"link\"")
 	flag.StringVar(&fl.out, "out", "",
 		"Output file (if empty, use <stdout>)")
 	flag.StringVar(&fl.out, "o", "",
 		"Short for \"-out\"")
+	flag.BoolVar(&fl.lazy, "lazy", false,
+		"Separate entry variables, index built on first use")
+	flag.StringVar(&fl.imp, "import", BundleImportPath,
+		"Import path of package bundle")
 	flag.StringVar(&fl.pkg, "pkg", "main",
 		"Package for the generated source file")
 	flag.StringVar(&fl.bundle, "bundle", "_bundle",
@@ -255,6 +761,26 @@ func init() {
 		"Short for '-gzip'")
 	flag.BoolVar(&fl.gzip, "gzip", false,
 		"Compress data before embedding")
+	flag.StringVar(&fl.format, "format", "go",
+		"Output format: \"go\" or \"bin\" (container)")
+	flag.StringVar(&fl.layout, "layout", "base64",
+		"Data layout: \"base64\" or \"blob\"")
+	flag.IntVar(&fl.reserve, "reserve", 0,
+		"Size of reserved region for the bundle (bytes)")
+	flag.BoolVar(&fl.stable, "stable", false,
+		"Sort entries and omit timestamp (git-friendly)")
+	flag.StringVar(&fl.split, "split", "",
+		"Split output per \"entry\", \"dir\", \"size\", or \"pkg\"")
+	flag.IntVar(&fl.maxsize, "maxsize", 0,
+		"Max size of split output files (bytes)")
+	flag.StringVar(&fl.pkgpath, "pkgpath", "",
+		"Import path of the output directory (for -split=pkg)")
+	flag.StringVar(&fl.names, "names", "",
+		"Emit entry names: \"const\" or accessor \"func\"")
+	flag.StringVar(&fl.prefix, "prefix", "Asset",
+		"Prefix of entry name constants or accessors")
+	flag.BoolVar(&fl.append, "append", false,
+		"Append container to output file (-format=bin)")
 	flag.BoolVar(&fl.always, "always", false,
 		"Regenerate output even if younger than input")
 	flag.BoolVar(&fl.always, "a", false,
@@ -0,0 +1,243 @@
+//go:build ignore
+
+// Generate the size tables in the package documentation
+//
+// Usage:
+//
+//   go run mksizes.go [mkbundle]
+//
+// Mksizes generates (in a temporary directory) the sample tree the
+// tables in doc.go refer to, bundles it with the "mkbundle" command
+// given (default: "./mkbundle") using the flags in the tables, and
+// prints the tables with the sizes of the embedded data. The sample
+// tree is generated from a fixed seed, so the tables it prints are
+// always the same (for a given mkbundle). See also "./all.sh sizes".
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Sample tree parameters
+const (
+	sampleSeed  int64 = 7
+	sampleJSON  int   = 300 // JSON documents of about 700 bytes
+	sampleHTML  int   = 100 // HTML pages of about 1200 bytes
+	sampleWords int   = 3000
+)
+
+var syllables = []string{"ka", "lo", "mi", "ren", "tor", "sa", "vel",
+	"dun", "pra", "shi", "go", "ber", "tal", "nix", "qu", "fa"}
+
+var rnd = rand.New(rand.NewSource(sampleSeed))
+var words []string
+
+// sentence returns "n" random words, separated by spaces
+func sentence(n int) string {
+	var l []string
+	for i := 0; i < n; i++ {
+		l = append(l, words[rnd.Intn(len(words))])
+	}
+	return strings.Join(l, " ")
+}
+
+// mkJSON returns the i'th JSON document of the sample tree
+func mkJSON(i int) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "{\n  \"id\": %d,\n", i)
+	fmt.Fprintf(&b, "  \"name\": %q,\n", sentence(2))
+	fmt.Fprintf(&b, "  \"description\": %q,\n", sentence(12))
+	fmt.Fprintf(&b, "  \"price\": %d.%02d,\n",
+		rnd.Intn(1000), rnd.Intn(100))
+	fmt.Fprintf(&b, "  \"available\": %v,\n", rnd.Intn(2) == 0)
+	fmt.Fprintf(&b, "  \"tags\": [%q, %q, %q],\n",
+		sentence(1), sentence(1), sentence(1))
+	fmt.Fprintf(&b, "  \"attributes\": [\n")
+	for j := 0; j < 5; j++ {
+		sep := ","
+		if j == 4 {
+			sep = ""
+		}
+		fmt.Fprintf(&b, "    {\"key\": %q, \"value\": %q, "+
+			"\"visible\": true}%s\n", sentence(1), sentence(3), sep)
+	}
+	fmt.Fprintf(&b, "  ],\n  \"links\": {\"self\": \"/api/items/%d\", "+
+		"\"collection\": \"/api/items\"}\n}\n", i)
+	return b.String()
+}
+
+// mkHTML returns the i'th HTML page of the sample tree
+func mkHTML(i int) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n"+
+		"  <meta charset=\"utf-8\">\n"+
+		"  <meta name=\"viewport\" "+
+		"content=\"width=device-width, initial-scale=1\">\n"+
+		"  <title>%s</title>\n"+
+		"  <link rel=\"stylesheet\" href=\"/static/site.css\">\n"+
+		"</head>\n<body>\n", sentence(3))
+	fmt.Fprintf(&b, "  <header class=\"site-header\">\n"+
+		"    <nav><a href=\"/\">Home</a> | <a href=\"/items\">Items</a>"+
+		" | <a href=\"/about\">About</a></nav>\n  </header>\n")
+	fmt.Fprintf(&b, "  <main class=\"content\">\n    <h1>%s</h1>\n",
+		sentence(4))
+	for j := 0; j < 4; j++ {
+		fmt.Fprintf(&b, "    <p class=\"paragraph\">%s</p>\n",
+			sentence(18))
+	}
+	fmt.Fprintf(&b, "  </main>\n  <footer class=\"site-footer\">\n"+
+		"    <p>Page %d &middot; Copyright &copy; Example Corp.</p>\n"+
+		"  </footer>\n</body>\n</html>\n", i)
+	return b.String()
+}
+
+// mkSample generates the sample tree in "dir"
+func mkSample(dir string) error {
+	for i := 0; i < sampleWords; i++ {
+		var w string
+		for n := 1 + rnd.Intn(4); n > 0; n-- {
+			w += syllables[rnd.Intn(len(syllables))]
+		}
+		words = append(words, w)
+	}
+	for _, d := range []string{"api", "pages"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0777); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < sampleJSON; i++ {
+		fn := filepath.Join(dir, "api", fmt.Sprintf("item-%03d.json", i))
+		if err := os.WriteFile(fn, []byte(mkJSON(i)), 0666); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < sampleHTML; i++ {
+		fn := filepath.Join(dir, "pages", fmt.Sprintf("page-%03d.html", i))
+		if err := os.WriteFile(fn, []byte(mkHTML(i)), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizes bundles "dir" with "mkb", using the "blob" layout and the
+// given flags, and returns the sizes of the blob and of the
+// dictionary, and the number of chunks.
+func sizes(mkb, dir, flags string) (blob, dict, chunks int, err error) {
+	out := filepath.Join(filepath.Dir(dir), "sizes.go")
+	args := []string{"-a", "-stable", "-layout=blob", "-o", out}
+	if flags != "" {
+		args = append(args, flags)
+	}
+	cmd := exec.Command(mkb, append(args, dir)...)
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		return 0, 0, 0, err
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), out, nil, 0)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		vs, ok := n.(*ast.ValueSpec)
+		if !ok || len(vs.Names) != 1 || len(vs.Values) != 1 {
+			return true
+		}
+		name := vs.Names[0].Name
+		switch {
+		case strings.HasPrefix(name, "_bundleBlob"):
+			blob += strLen(vs.Values[0])
+		case name == "_bundleDict":
+			dict += strLen(vs.Values[0])
+		case name == "_bundleChunks":
+			if cl, ok := vs.Values[0].(*ast.CompositeLit); ok {
+				chunks = len(cl.Elts)
+			}
+		}
+		return false
+	})
+	return blob, dict, chunks, nil
+}
+
+// strLen returns the total length of the string literals in "x"
+func strLen(x ast.Expr) int {
+	var n int
+	ast.Inspect(x, func(n1 ast.Node) bool {
+		if bl, ok := n1.(*ast.BasicLit); ok && bl.Kind == token.STRING {
+			s, err := strconv.Unquote(bl.Value)
+			if err != nil {
+				log.Fatal(err)
+			}
+			n += len(s)
+		}
+		return true
+	})
+	return n
+}
+
+func main() {
+	mkb := "./mkbundle"
+	if len(os.Args) > 1 {
+		mkb = os.Args[1]
+	}
+	mkb, err := filepath.Abs(mkb)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tmp, err := os.MkdirTemp("", "mksizes")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "sample")
+	if err := mkSample(dir); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("  %-13s  %6s  %10s  %6s\n",
+		"Flags", "Data", "Dictionary", "Total")
+	for _, fl := range []string{"", "-gzip", "-dict=4096",
+		"-dict=16384", "-dict=32768"} {
+		blob, dict, _, err := sizes(mkb, dir, fl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		name, sdict := fl, strconv.Itoa(dict)
+		if fl == "" {
+			name = "(none)"
+		}
+		if dict == 0 {
+			sdict = "-"
+		}
+		fmt.Printf("  %-13s  %6d  %10s  %6d\n",
+			name, blob, sdict, blob+dict)
+	}
+	fmt.Println()
+
+	fmt.Printf("  %-14s  %6s  %6s\n", "Flags", "Data", "Chunks")
+	for _, fl := range []string{"-gzip", "-solid=16384",
+		"-solid=65536", "-solid=262144"} {
+		blob, _, chunks, err := sizes(mkb, dir, fl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		schunks := strconv.Itoa(chunks)
+		if chunks == 0 {
+			schunks = "-"
+		}
+		fmt.Printf("  %-14s  %6d  %6s\n", fl, blob, schunks)
+	}
+}
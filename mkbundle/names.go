@@ -0,0 +1,144 @@
+// Entry name constants and accessors (-names)
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Words in entry names that are spelled all-caps in identifiers
+var initialisms = map[string]bool{
+	"API": true, "CSS": true, "CSV": true, "GIF": true, "HTML": true,
+	"HTTP": true, "ICO": true, "ID": true, "JPEG": true, "JPG": true,
+	"JS": true, "JSON": true, "PDF": true, "PNG": true, "SQL": true,
+	"SVG": true, "TXT": true, "URL": true, "XML": true, "YAML": true,
+}
+
+// mangle converts entry name "name" to a Go identifier starting with
+// "prefix". The name is split in words at every character that is not
+// a letter or a digit, and the words are joined with their first
+// letter capitalized (or all-caps, for known initialisms). For
+// example, with prefix "Asset", "tmpl/index.html" becomes
+// "AssetTmplIndexHTML". Mangled names never contain underscores
+// (other than those in "prefix").
+func mangle(prefix, name string) string {
+	var words []string
+	var id string
+
+	words = strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	id = prefix
+	for _, w := range words {
+		if initialisms[strings.ToUpper(w)] {
+			id += strings.ToUpper(w)
+			continue
+		}
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		id += string(r)
+	}
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) && id[0] != '_' {
+		id = "X" + id
+	}
+	return id
+}
+
+// mkNames returns the identifiers, starting with "prefix", for the
+// names of "files". Names are mangled in sorted order. If several
+// names mangle to the same identifier, the first gets it as-is, and
+// the rest get a "_2", "_3", etc. suffix (and, if "warn" is true, a
+// warning is printed). This way identifiers depend only on the set
+// of names.
+func mkNames(prefix string, files []srcFile,
+	warn bool) (names []string, ids map[string]string) {
+	var used map[string]string
+
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	sort.Strings(names)
+	ids = make(map[string]string)
+	used = make(map[string]string)
+	for _, nm := range names {
+		id := mangle(prefix, nm)
+		if prev, ok := used[id]; ok {
+			base := id
+			for i := 2; ; i++ {
+				id = base + "_" + strconv.Itoa(i)
+				if _, ok := used[id]; !ok {
+					break
+				}
+			}
+			if warn {
+				log.Printf("Name collision: %s and %s, "+
+					"using %s for %s", prev, nm, id, nm)
+			}
+		}
+		used[id] = nm
+		ids[nm] = id
+	}
+	return names, ids
+}
+
+// emitNames emits a constant ("-names=const"), or an accessor
+// function ("-names=func") for the name of every entry in "files".
+// With "-lazy", accessors return the entry variables, except for link
+// entries, which are resolved through the index.
+func emitNames(w io.Writer, files []srcFile) error {
+	var names []string
+	var ids map[string]string
+	var err error
+
+	names, ids = mkNames(fl.prefix, files, true)
+	if fl.names == "func" && fl.lazy {
+		_, vars := mkNames(fl.bundle+"_", files, false)
+		links := make(map[string]bool)
+		for _, f := range files {
+			if f.link != "" {
+				links[f.name] = true
+			}
+		}
+		for _, nm := range names {
+			if links[nm] {
+				_, err = fmt.Fprintf(w, AccessorFormat,
+					ids[nm], nm, fl.index+"()")
+			} else {
+				_, err = fmt.Fprintf(w, LazyAccessorFormat,
+					ids[nm], nm, vars[nm])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if fl.names == "func" {
+		for _, nm := range names {
+			_, err = fmt.Fprintf(w, AccessorFormat,
+				ids[nm], nm, fl.index)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err = fmt.Fprintf(w, NamesHeadFormat)
+	if err != nil {
+		return err
+	}
+	for _, nm := range names {
+		_, err = fmt.Fprintf(w, NameFormat, ids[nm], nm)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, NamesFootFormat)
+	return err
+}
@@ -0,0 +1,201 @@
+// mkbundle replace subcommand
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/npat-efault/bundle"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// cmdReplace implements:
+//
+//	mkbundle replace [flags] <executable> <file-or-dir>
+//
+// It replaces the bundle embedded in <executable>, either in a
+// reserved region or appended to it, with a bundle generated from
+// <file-or-dir>.
+func cmdReplace(args []string) error {
+	var buf bytes.Buffer
+	var exe, data []byte
+	var off, n int64
+	var err error
+
+	if len(args) != 2 {
+		return errors.New("usage: replace [flags] " +
+			"<executable> <file-or-dir>")
+	}
+	exe, err = ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	bin = NewContainer()
+	err = emitBundle(&buf, args[1])
+	if err != nil {
+		return err
+	}
+
+	off, n, err = findRegion(exe)
+	if err == nil {
+		// Rewrite reserved region in place
+		data, err = mkRegion(buf.Bytes(), int(n))
+		if err != nil {
+			return fmt.Errorf("%s: %s", args[0], err)
+		}
+		copy(exe[off:], data)
+		if fl.verbose {
+			log.Printf("Replacing bundle in reserved region "+
+				"of %s (%d of %d bytes used)", args[0],
+				buf.Len()+bundle.RegionHeaderSize, n)
+		}
+	} else {
+		off, n, err = bundle.ReadTrailer(bytes.NewReader(exe),
+			int64(len(exe)))
+		if err != nil {
+			return fmt.Errorf("%s: no reserved region or "+
+				"appended bundle found", args[0])
+		}
+		// Replace appended container
+		exe = exe[:off]
+		exe = append(exe, buf.Bytes()...)
+		exe = binary.LittleEndian.AppendUint64(exe, uint64(off))
+		exe = binary.LittleEndian.AppendUint64(exe,
+			uint64(buf.Len()))
+		exe = append(exe, bundle.TrailerMagic...)
+		if fl.verbose {
+			log.Printf("Replacing bundle appended to %s",
+				args[0])
+		}
+	}
+	err = verifyBundle(exe, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("%s: verify failed: %s", args[0], err)
+	}
+	err = writeFileAtomic(args[0], exe)
+	if err != nil {
+		return err
+	}
+	// Verify what was actually written
+	exe, err = ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	err = verifyBundle(exe, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("%s: verify failed: %s", args[0], err)
+	}
+	return nil
+}
+
+// findRegion locates the (single) reserved region in "exe" and
+// returns its offset and size.
+func findRegion(exe []byte) (off, size int64, err error) {
+	var le = binary.LittleEndian
+	var found int
+
+	magic := []byte(bundle.RegionMagic)
+	for i := 0; ; {
+		j := bytes.Index(exe[i:], magic)
+		if j < 0 {
+			break
+		}
+		i += j
+		r := exe[i:]
+		if len(r) >= bundle.RegionHeaderSize {
+			sz, n := le.Uint64(r[8:]), le.Uint64(r[16:])
+			if sz <= uint64(len(r)) &&
+				n <= sz-uint64(bundle.RegionHeaderSize) &&
+				bytes.HasPrefix(r[bundle.RegionHeaderSize:],
+					[]byte(bundle.ContainerMagic)) {
+				off, size = int64(i), int64(sz)
+				found++
+			}
+		}
+		i += len(magic)
+	}
+	switch found {
+	case 0:
+		return 0, 0, errors.New("no reserved region")
+	case 1:
+		return off, size, nil
+	default:
+		return 0, 0, errors.New("multiple reserved regions")
+	}
+}
+
+// verifyBundle checks that the bundle embedded in "exe" is the
+// container "c", and that all its entries can be decoded.
+func verifyBundle(exe []byte, c []byte) error {
+	var idx bundle.Index
+	var off, n int64
+	var err error
+
+	off, n, err = findRegion(exe)
+	if err == nil {
+		r := exe[off+int64(bundle.RegionHeaderSize) : off+n]
+		if len(r) < len(c) || !bytes.Equal(r[:len(c)], c) {
+			return errors.New("region contents mismatch")
+		}
+		idx, err = bundle.LoadRegion(exe[off : off+n])
+	} else {
+		off, n, err = bundle.ReadTrailer(bytes.NewReader(exe),
+			int64(len(exe)))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(exe[off:off+n], c) {
+			return errors.New("appended bundle mismatch")
+		}
+		idx, err = bundle.Load(bytes.NewReader(exe[off:off+n]), n)
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range idx {
+		if e.Link != "" || e.IsDir() {
+			continue
+		}
+		// Decode verifies the checksum
+		_, err = e.Decode(0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces file "name" with a new file containing
+// "data" and having the same permissions.
+func writeFileAtomic(name string, data []byte) error {
+	var fi os.FileInfo
+	var f *os.File
+	var err error
+
+	fi, err = os.Stat(name)
+	if err != nil {
+		return err
+	}
+	f, err = ioutil.TempFile(filepath.Dir(name),
+		"."+filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(fi.Mode())
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
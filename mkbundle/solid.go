@@ -0,0 +1,186 @@
+// Compressing entries together in chunks (-solid)
+
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+)
+
+// A chunk is a group of consecutive files, compressed together
+type chunk struct {
+	files []srcFile
+	size  int
+}
+
+// Location of the data of a file in the chunks
+type chunkLoc struct {
+	chunk int
+	off   int
+}
+
+// Chunks of the bundle, for "-solid". Nil if not used.
+var chunks []chunk
+
+// planChunks assigns the regular files in "files" to chunks, in
+// order, starting a new chunk when the current one reaches "-solid"
+// bytes. It returns the location of the data of every file, by file
+// path. With "-dedup", files with the same contents share the same
+// location.
+func planChunks(files []srcFile) ([]chunk, map[string]chunkLoc, error) {
+	var chs []chunk
+	var cur chunk
+	var locs map[string]chunkLoc
+	var sums map[[sha256.Size]byte]chunkLoc
+
+	locs = make(map[string]chunkLoc)
+	sums = make(map[[sha256.Size]byte]chunkLoc)
+	for _, f := range files {
+		if f.link != "" || f.mode.IsDir() {
+			continue
+		}
+		if fl.dedup {
+			sum, err := fileSum(f)
+			if err != nil {
+				return nil, nil, err
+			}
+			if l, ok := sums[sum]; ok {
+				locs[f.path] = l
+				dedupSaved += f.size
+				continue
+			}
+			sums[sum] = chunkLoc{len(chs), cur.size}
+		}
+		locs[f.path] = chunkLoc{len(chs), cur.size}
+		cur.files = append(cur.files, f)
+		cur.size += f.size
+		if cur.size >= fl.solid {
+			chs = append(chs, cur)
+			cur = chunk{}
+		}
+	}
+	if len(cur.files) > 0 {
+		chs = append(chs, cur)
+	}
+	return chs, locs, nil
+}
+
+// emitSolid emits the bundle entries for "files", with the data of
+// the regular files compressed together in chunks. The chunks are
+// emitted (by emitBundleFooter) after the entries.
+func emitSolid(w io.Writer, files []srcFile) error {
+	var locs map[string]chunkLoc
+	var err error
+
+	chunks, locs, err = planChunks(files)
+	if err != nil {
+		return err
+	}
+	defer func() { chunks = nil }()
+	err = emitBundleHeader(w, fl.pkg, fl.bundle, fl.index)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if fl.verbose {
+			log.Printf("+ %s", f.name)
+		}
+		l, ok := locs[f.path]
+		if !ok {
+			// Not a regular file
+			err = emitEntry(w, f)
+		} else {
+			_, err = fmt.Fprintf(w, SolidEntryFormat, f.name,
+				f.size, fl.bundle+"Chunks", l.chunk, l.off)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = emitBundleFooter(w, fl.bundle, fl.index)
+	if err != nil {
+		return err
+	}
+	return emitBundleEnd(w, files)
+}
+
+// emitChunks emits the chunks (if any) as an array of bundle.Chunk,
+// compressing the data of the files in every chunk together. For the
+// "blob" layout, the compressed data are added to the blob.
+func emitChunks(w io.Writer) error {
+	var err error
+
+	if chunks == nil {
+		return nil
+	}
+	_, err = fmt.Fprintf(w, ChunksHeadFormat,
+		fl.bundle+"Chunks", len(chunks))
+	if err != nil {
+		return err
+	}
+	for i, c := range chunks {
+		var zw io.WriteCloser
+		var off int
+
+		if blob != nil {
+			off = blob.buf.Len()
+			zw = gzip.NewWriter(&blob.buf)
+		} else {
+			_, err = fmt.Fprintf(w, ChunkHeadFormat, c.size)
+			if err != nil {
+				return err
+			}
+			zw = newGoZipWriter(w, ChunkFootFormat)
+		}
+		for _, f := range c.files {
+			err = copyFile(zw, f)
+			if err != nil {
+				zw.Close()
+				return err
+			}
+		}
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+		if blob != nil {
+			_, err = fmt.Fprintf(w, ChunkBlobFormat, c.size,
+				blob.name, off, blob.buf.Len())
+			if err != nil {
+				return err
+			}
+		}
+		if fl.verbose {
+			log.Printf("Chunk %d: %d files, %d bytes",
+				i, len(c.files), c.size)
+		}
+	}
+	_, err = fmt.Fprintf(w, ChunksFootFormat)
+	return err
+}
+
+// copyFile writes the contents of file "f" to "w". Returns an error if
+// the size of the file has changed since it was collected (which
+// would invalidate the offsets of the entries in its chunk).
+func copyFile(w io.Writer, f srcFile) error {
+	var in io.ReadCloser
+	var n int64
+	var err error
+
+	in, err = f.open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	n, err = io.Copy(w, in)
+	if err != nil {
+		return err
+	}
+	if n != int64(f.size) {
+		return fmt.Errorf("%s: file changed while bundling", f.path)
+	}
+	return nil
+}
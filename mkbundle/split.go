@@ -0,0 +1,443 @@
+// Splitting generated output in multiple files (-split)
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"go/token"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Max length of the mangled entry or directory name in part file
+// names
+const partNameLen int = 40
+
+// partBase returns the directory and the base name of the main
+// output file "out" (without the ".go" extension), and the suffix
+// of the part file names ("_test.go" if the main file is a test
+// file, or ".go" otherwise).
+func partBase(out string) (dir, base, suffix string) {
+	dir, base = filepath.Split(out)
+	base = strings.TrimSuffix(base, ".go")
+	suffix = ".go"
+	if strings.HasSuffix(base, "_test") {
+		base = strings.TrimSuffix(base, "_test")
+		suffix = "_test.go"
+	}
+	return dir, base, suffix
+}
+
+// partID returns an identifier for the part holding the entries of
+// "key" (an entry name, or a directory). The identifier is derived
+// from the key, so it stays the same every time the bundle is
+// regenerated.
+func partID(key string) string {
+	var m []byte
+
+	for _, c := range []byte(key) {
+		if len(m) >= partNameLen {
+			break
+		}
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' {
+			m = append(m, c)
+		} else {
+			m = append(m, '_')
+		}
+	}
+	// The hash (always last) also keeps the file name from ending
+	// in a _GOOS or _GOARCH suffix.
+	h := sha1.Sum([]byte(key))
+	return string(m) + "_" + hex.EncodeToString(h[:4])
+}
+
+// partFiles returns the names of the existing part files of the main
+// output file "out". Part files are next to the main file, or (for
+// "-split=pkg") in subdirectories of its directory.
+func partFiles(out string) ([]string, error) {
+	var parts []string
+
+	dir, base, suffix := partBase(out)
+	marker := fmt.Sprintf(PartMarkerFormat, base+suffix)
+	l, err := filepath.Glob(filepath.Join(dir, base+"_*"+suffix))
+	if err != nil {
+		return nil, err
+	}
+	ls, err := filepath.Glob(filepath.Join(dir, "*", base+"_*"+suffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range append(l, ls...) {
+		if suffix == ".go" && strings.HasSuffix(fn, "_test.go") {
+			continue
+		}
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(f)
+		// The marker is in one of the first lines
+		for i := 0; i < 3; i++ {
+			ln, err := r.ReadString('\n')
+			if ln == marker {
+				parts = append(parts, fn)
+				break
+			}
+			if err != nil {
+				break
+			}
+		}
+		f.Close()
+	}
+	return parts, nil
+}
+
+// A part is a group of entries emitted in a part file
+type part struct {
+	id    string // Part identifier, used in the part file name
+	pkg   string // Package of the part, for "-split=pkg"
+	files []srcFile
+	shard bool // Emit in several part files, see emitShards
+}
+
+// pkgName returns the name of the package (and of its directory) for
+// the entries under top-level directory "dir", for "-split=pkg".
+// Returns an error if the name is not a valid package name.
+func pkgName(dir string) (string, error) {
+	name := strings.ToLower(mangle("", dir))
+	if token.IsKeyword(name) || name == "main" {
+		return "", fmt.Errorf("%s: cannot be split in package %q",
+			dir, name)
+	}
+	return name, nil
+}
+
+// mkParts groups "files" in parts, as selected by the "-split" flag
+func mkParts(files []srcFile) ([]part, error) {
+	var keys []string
+	var groups map[string][]srcFile
+	var parts []part
+
+	groups = make(map[string][]srcFile)
+	for _, f := range files {
+		key := filepath.ToSlash(f.name)
+		switch fl.split {
+		case "dir":
+			key = path.Dir(key)
+		case "size":
+			key = ""
+		case "pkg":
+			i := strings.Index(key, "/")
+			if i < 0 {
+				key = ""
+				break
+			}
+			pkg, err := pkgName(key[:i])
+			if err != nil {
+				return nil, err
+			}
+			key = pkg
+		}
+		if groups[key] == nil {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], f)
+	}
+	for _, key := range keys {
+		switch fl.split {
+		case "entry", "dir":
+			parts = append(parts,
+				part{id: partID(key), files: groups[key]})
+		case "size", "pkg":
+			parts = append(parts, part{id: "001", pkg: key,
+				files: groups[key], shard: fl.maxsize > 0})
+		}
+	}
+	return parts, nil
+}
+
+// emitSplit emits the bundle entries for "files" in part files, one
+// for every entry, every directory, or every "-maxsize" bytes of
+// generated code (depending on the "-split" flag), next to the main
+// output file. For "-split=pkg" the entries under every top-level
+// directory are emitted in a separate package, in a subdirectory
+// next to the main output file; the packages register their entries
+// with package bundle, and the main output file (which imports them)
+// merges them in the index. The main output file (written to "w")
+// declares the index. Stale part files (from previous runs) are
+// removed.
+func emitSplit(w io.Writer, files []srcFile) error {
+	var parts []part
+	var keep map[string]bool
+	var imports string
+	var err error
+
+	parts, err = mkParts(files)
+	if err != nil {
+		return err
+	}
+	dir, base, suffix := partBase(fl.out)
+	if fl.split == "pkg" {
+		seen := make(map[string]bool)
+		for _, p := range parts {
+			if p.pkg == "" || seen[p.pkg] {
+				continue
+			}
+			seen[p.pkg] = true
+			imports += fmt.Sprintf(PkgImportFormat,
+				path.Join(fl.pkgpath, p.pkg))
+		}
+		_, err = fmt.Fprintf(w, PkgHeadFormat,
+			fl.pkg, registryKey(), fl.index,
+			importSpec(), generated(), base, imports)
+	} else {
+		_, err = fmt.Fprintf(w, SplitHeadFormat,
+			fl.pkg, fl.bundle, fl.index,
+			importSpec(), generated(), base)
+	}
+	if err != nil {
+		return err
+	}
+	err = emitDict(w)
+	if err != nil {
+		return err
+	}
+	err = emitBundleEnd(w, files)
+	if err != nil {
+		return err
+	}
+
+	keep = make(map[string]bool)
+	for _, p := range parts {
+		pdir := dir
+		if p.pkg != "" {
+			pdir = filepath.Join(dir, p.pkg)
+			err = os.MkdirAll(pdir, 0777)
+			if err != nil {
+				return err
+			}
+		}
+		if p.shard {
+			fns, err := emitShards(pdir, p, base+suffix)
+			if err != nil {
+				return err
+			}
+			for _, fn := range fns {
+				keep[fn] = true
+			}
+			continue
+		}
+		fn := filepath.Join(pdir, base+"_"+p.id+suffix)
+		err = emitPart(fn, p, base+suffix)
+		if err != nil {
+			return err
+		}
+		keep[fn] = true
+	}
+
+	old, err := partFiles(fl.out)
+	if err != nil {
+		return err
+	}
+	for _, fn := range old {
+		if keep[fn] {
+			continue
+		}
+		if fl.verbose {
+			log.Printf("Removing stale %s", fn)
+		}
+		err = os.Remove(fn)
+		if err != nil {
+			return err
+		}
+		if d := filepath.Dir(fn); d != filepath.Clean(dir) {
+			// Remove package directory, if left empty
+			os.Remove(d)
+		}
+	}
+	return nil
+}
+
+// registryKey returns the name under which the entries of a bundle
+// split in packages are registered with package bundle
+func registryKey() string {
+	return fl.pkgpath + "." + fl.index
+}
+
+// emitPart writes part file "fn" (of main output file "main") with
+// the entries of part "p".
+func emitPart(fn string, p part, main string) error {
+	var code bytes.Buffer
+	var err error
+
+	if blob != nil {
+		blob = NewBlob(fl.bundle + "Blob_" + p.id)
+	}
+	shared, err = newShared(p.files, fl.bundle+"Data_"+p.id+"_")
+	if err != nil {
+		return err
+	}
+	defer func() { shared = nil }()
+	err = emitEntries(&code, p.files)
+	if err != nil {
+		return err
+	}
+	return writePart(fn, p, main, code.Bytes())
+}
+
+// emitShards emits the entries of part "p" in part files (in
+// directory "dir", numbered in order) with (up to) "-maxsize" bytes
+// of generated code each. A part file is larger only if it holds a
+// single entry that is larger. The size of the code is measured as
+// the entries are emitted: An entry that does not fit is removed from
+// the part file, and emitted again in the next one. Returns the
+// names of the part files.
+func emitShards(dir string, p part, main string) ([]string, error) {
+	var code bytes.Buffer
+	var cur part
+	var grp *Shared
+	var fns []string
+	var size int
+	var err error
+
+	_, base, suffix := partBase(fl.out)
+	// Data are shared by the entries of the same part file
+	grp, err = newShared(p.files, "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { shared = nil }()
+	start := func() {
+		cur = part{id: fmt.Sprintf("%03d", len(fns)+1), pkg: p.pkg}
+		code.Reset()
+		size = 0
+		if blob != nil {
+			blob = NewBlob(fl.bundle + "Blob_" + cur.id)
+		}
+		shared = grp.reset(fl.bundle + "Data_" + cur.id + "_")
+	}
+	// Size of the code generated since the given marks
+	measure := func(cl, bl, sl int) int {
+		n := code.Len() - cl + shared.Len() - sl
+		if blob != nil {
+			n += blobLen(blob.buf.Bytes()[bl:])
+		}
+		return n
+	}
+	flush := func() error {
+		fn := filepath.Join(dir, base+"_"+cur.id+suffix)
+		fns = append(fns, fn)
+		return writePart(fn, cur, main, code.Bytes())
+	}
+	start()
+	for _, f := range p.files {
+		cl, bl, sl, saved := code.Len(), blob.Len(), shared.Len(),
+			dedupSaved
+		err = emitEntry(&code, f)
+		if err != nil {
+			return nil, err
+		}
+		sz := measure(cl, bl, sl)
+		if len(cur.files) > 0 && size+sz > fl.maxsize {
+			code.Truncate(cl)
+			blob.truncate(bl)
+			shared.truncate(sl)
+			dedupSaved = saved
+			err = flush()
+			if err != nil {
+				return nil, err
+			}
+			start()
+			err = emitEntry(&code, f)
+			if err != nil {
+				return nil, err
+			}
+			sz = measure(0, 0, 0)
+		}
+		if fl.verbose {
+			log.Printf("+ %s", f.name)
+		}
+		cur.files = append(cur.files, f)
+		size += sz
+	}
+	if len(cur.files) > 0 {
+		err = flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fns, nil
+}
+
+// writePart writes part file "fn" (of main output file "main") for
+// part "p", with the (already generated) code of its entries "code".
+func writePart(fn string, p part, main string, code []byte) error {
+	var f *os.File
+	var err error
+
+	f, err = os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = emitSource(f, func(w io.Writer) error {
+		return emitPartSource(w, p, main, code)
+	})
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// emitPartSource emits the source of a part file, with the code of
+// its entries "code".
+func emitPartSource(w io.Writer, p part, main string, code []byte) error {
+	var err error
+
+	switch {
+	case p.pkg != "":
+		_, err = fmt.Fprintf(w, RegisterHeadFormat,
+			p.pkg, fl.bundle, registryKey(),
+			importSpec(), generated(), main)
+	case fl.split == "pkg":
+		_, err = fmt.Fprintf(w, RegisterHeadFormat,
+			fl.pkg, fl.bundle, registryKey(),
+			importSpec(), generated(), main)
+	default:
+		_, err = fmt.Fprintf(w, PartHeadFormat,
+			fl.pkg, fl.bundle, fl.index,
+			importSpec(), generated(), main)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(code)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, PartFootFormat)
+	if err != nil {
+		return err
+	}
+	if blob != nil {
+		err = emitBlob(w, blob)
+		if err != nil {
+			return err
+		}
+	}
+	err = emitShared(w, shared)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, BundleEndFormat)
+	return err
+}
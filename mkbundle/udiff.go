@@ -0,0 +1,210 @@
+// Unified diffs of text lines
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Lines of context in unified diffs
+const diffContext int = 3
+
+// Maximum edit distance diffLines searches for. The memory it needs
+// grows with the square of the distance.
+const diffMaxEdits int = 1000
+
+// A diffOp is an operation of an edit script: Keep (' '), delete
+// ('-') or insert ('+') a line. Fields "ai" and "bi" are the number
+// of lines of the old and new text before the line.
+type diffOp struct {
+	op     byte
+	line   string
+	ai, bi int
+}
+
+// splitLines splits "s" in lines, keeping the line terminators
+func splitLines(s string) []string {
+	l := strings.SplitAfter(s, "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+// diffLines returns the shortest edit script that turns lines "a"
+// into lines "b" (Myers' algorithm). If the script is longer than
+// diffMaxEdits, it returns one that deletes all of "a" and inserts
+// all of "b".
+func diffLines(a, b []string) []diffOp {
+	var n, m, d int
+	var v []int
+	var trace [][]int
+	var ops []diffOp
+
+	n, m = len(a), len(b)
+	v = make([]int, 2*(n+m)+3)
+	off := n + m + 1
+	// less reports if we get to diagonal k by moving down (insert),
+	// rather than right (delete), from the previous round.
+	less := func(v []int, vo, k, d int) bool {
+		return k == -d || (k != d && v[vo+k-1] < v[vo+k+1])
+	}
+loop:
+	for d = 0; d <= n+m; d++ {
+		if d > diffMaxEdits {
+			return replaceLines(a, b)
+		}
+		// Keep diagonals -d..d of the previous round
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x, y int
+			if less(v, off, k, d) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break loop
+			}
+		}
+	}
+	// Backtrack
+	x, y := n, m
+	for ; d > 0; d-- {
+		tv := trace[d]
+		k := x - y
+		pk := k - 1
+		if less(tv, d, k, d) {
+			pk = k + 1
+		}
+		px := tv[d+pk]
+		py := px - pk
+		for x > px && y > py {
+			x, y = x-1, y-1
+			ops = append(ops, diffOp{' ', a[x], x, y})
+		}
+		if x == px {
+			y--
+			ops = append(ops, diffOp{'+', b[y], x, y})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', a[x], x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		ops = append(ops, diffOp{' ', a[x], x, y})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaceLines returns the edit script that deletes all lines "a"
+// and inserts all lines "b".
+func replaceLines(a, b []string) []diffOp {
+	var ops []diffOp
+
+	ops = make([]diffOp, 0, len(a)+len(b))
+	for i := range a {
+		ops = append(ops, diffOp{'-', a[i], i, 0})
+	}
+	for j := range b {
+		ops = append(ops, diffOp{'+', b[j], len(a), j})
+	}
+	return ops
+}
+
+// unifiedDiff writes to "w" the unified diff between texts "a" and
+// "b", named "aname" and "bname".
+func unifiedDiff(w io.Writer, aname, bname, a, b string) error {
+	var ops []diffOp
+	var err error
+
+	ops = diffLines(splitLines(a), splitLines(b))
+	_, err = fmt.Fprintf(w, "--- %s\n+++ %s\n", aname, bname)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(ops); {
+		// Find next change
+		for i < len(ops) && ops[i].op == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		// Merge changes separated by few unchanged lines
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].op != ' ' {
+				end = j
+			} else if j-end > 2*diffContext {
+				break
+			}
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		stop := end + diffContext + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		err = writeHunk(w, ops[start:stop])
+		if err != nil {
+			return err
+		}
+		i = stop
+	}
+	return nil
+}
+
+// writeHunk writes a unified-diff hunk with edit operations "ops"
+func writeHunk(w io.Writer, ops []diffOp) error {
+	var al, bl int
+	var err error
+
+	for _, o := range ops {
+		if o.op != '+' {
+			al++
+		}
+		if o.op != '-' {
+			bl++
+		}
+	}
+	as, bs := ops[0].ai, ops[0].bi
+	if al > 0 {
+		as++
+	}
+	if bl > 0 {
+		bs++
+	}
+	_, err = fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", as, al, bs, bl)
+	if err != nil {
+		return err
+	}
+	for _, o := range ops {
+		_, err = fmt.Fprintf(w, "%c%s", o.op, o.line)
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(o.line, "\n") {
+			_, err = fmt.Fprintf(w,
+				"\n\\ No newline at end of file\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numLines returns lines "1".."n", with line "i" replaced by
+// repl[i], if present.
+func numLines(n int, repl map[int]string) string {
+	var b strings.Builder
+
+	for i := 1; i <= n; i++ {
+		if s, ok := repl[i]; ok {
+			fmt.Fprintf(&b, "%s\n", s)
+		} else {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	var tests = []struct {
+		a, b string
+		exp  string
+	}{
+		// Identical texts
+		{"x\ny\n", "x\ny\n", ""},
+		// Change in the middle, with context
+		{numLines(10, nil), numLines(10, map[int]string{5: "five"}),
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"},
+		// Changes close together are merged in one hunk
+		{numLines(20, nil),
+			numLines(20, map[int]string{5: "five", 11: "eleven"}),
+			"@@ -2,13 +2,13 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n" +
+				" 8\n 9\n 10\n-11\n+eleven\n 12\n 13\n 14\n"},
+		// ... but not if they are further apart
+		{numLines(20, nil),
+			numLines(20, map[int]string{5: "five", 13: "thirteen"}),
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n" +
+				"@@ -10,7 +10,7 @@\n 10\n 11\n 12\n-13\n+thirteen\n" +
+				" 14\n 15\n 16\n"},
+		// Insertions and deletions at the ends
+		{"", "x\n", "@@ -0,0 +1,1 @@\n+x\n"},
+		{"x\n", "", "@@ -1,1 +0,0 @@\n-x\n"},
+		{"one\ntwo\nthree\n", "one\n2\nthree\nfour\n",
+			"@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n"},
+		// Missing newline at the end
+		{"x\ny", "x\nz",
+			"@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n" +
+				"+z\n\\ No newline at end of file\n"},
+		{"x\ny", "x\ny\n",
+			"@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n" +
+				"+y\n"},
+	}
+	for _, tst := range tests {
+		var b strings.Builder
+
+		err := unifiedDiff(&b, "a", "b", tst.a, tst.b)
+		if err != nil {
+			t.Fatalf("unifiedDiff(%q, %q): %s", tst.a, tst.b, err)
+		}
+		exp := "--- a\n+++ b\n" + tst.exp
+		if b.String() != exp {
+			t.Fatalf("unifiedDiff(%q, %q):\n%s\nExpected:\n%s",
+				tst.a, tst.b, b.String(), exp)
+		}
+	}
+}
+
+// applyOps returns the old and new texts of edit script "ops"
+func applyOps(ops []diffOp) (a, b string) {
+	for _, o := range ops {
+		if o.op != '+' {
+			a += o.line
+		}
+		if o.op != '-' {
+			b += o.line
+		}
+	}
+	return a, b
+}
+
+func TestDiffLines(t *testing.T) {
+	// Every other line replaced: An edit distance of 2000
+	repl := make(map[int]string)
+	for i := 2; i <= 2000; i += 2 {
+		repl[i] = fmt.Sprintf("x%d", i)
+	}
+	var tests = []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a\nb\nc\n", "a\nb\nc\n", 0},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		{"a\nb\n", "c\nd\n", 4},
+		// More than diffMaxEdits: All lines are replaced
+		{numLines(2000, nil), numLines(2000, repl), 4000},
+	}
+	for _, tst := range tests {
+		var edits int
+
+		ops := diffLines(splitLines(tst.a), splitLines(tst.b))
+		a, b := applyOps(ops)
+		if a != tst.a || b != tst.b {
+			t.Fatalf("diffLines(%.20q, %.20q): Bad script",
+				tst.a, tst.b)
+		}
+		for _, o := range ops {
+			if o.op != ' ' {
+				edits++
+			}
+		}
+		if edits != tst.edits {
+			t.Fatalf("diffLines(%.20q, %.20q): %d edits, "+
+				"expected %d", tst.a, tst.b, edits, tst.edits)
+		}
+	}
+}
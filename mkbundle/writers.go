@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
-	"compress/gzip"
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
+	"github.com/npat-efault/bundle"
 	"io"
+	"strings"
 )
 
 // Add "nl" to stream, after every "len" bytes
@@ -50,29 +54,37 @@ func (lb *LineBreaker) Write(p []byte) (int, error) {
 }
 
 func emitFileHeader(w io.Writer, fname string, sz int, zip bool) error {
-	_, err := fmt.Fprintf(w, FileHeadFormat, fname, sz, zip)
+	_, err := fmt.Fprintf(w, FileHeadFormat, fname, sz, zip, dictField())
 	return err
 }
 
 // io.Writer <- bufio.Writer <- LineBreaker <- base64.Encoder
 type GoWriter struct {
-	w64 io.WriteCloser
-	wlb *LineBreaker
-	wb  *bufio.Writer
+	w64  io.WriteCloser
+	wlb  *LineBreaker
+	wb   *bufio.Writer
+	foot string
 }
 
 func NewGoWriter(w io.Writer, fname string, sz int) (*GoWriter, error) {
 	var err error
-	var gw *GoWriter
 	err = emitFileHeader(w, fname, sz, false)
 	if err != nil {
 		return nil, err
 	}
-	gw = &GoWriter{}
+	return newGoWriter(w, FileFootFormat), nil
+}
+
+// newGoWriter returns a GoWriter that writes "foot" (instead of the
+// entry footer) on Close.
+func newGoWriter(w io.Writer, foot string) *GoWriter {
+	var gw *GoWriter
+
+	gw = &GoWriter{foot: foot}
 	gw.wb = bufio.NewWriter(w)
 	gw.wlb = NewLineBreaker(gw.wb, 76, "\n")
 	gw.w64 = base64.NewEncoder(base64.StdEncoding, gw.wlb)
-	return gw, nil
+	return gw
 }
 
 func (gw *GoWriter) Write(p []byte) (int, error) {
@@ -87,7 +99,7 @@ func (gw *GoWriter) Close() error {
 		_ = gw.wb.Flush()
 		return err
 	}
-	_, err = fmt.Fprintf(gw.wb, FileFootFormat)
+	_, err = gw.wb.WriteString(gw.foot)
 	if err != nil {
 		_ = gw.wb.Flush()
 		return err
@@ -95,11 +107,11 @@ func (gw *GoWriter) Close() error {
 	return gw.wb.Flush()
 }
 
-// GoWriter <- gzip.Writer :
+// GoWriter <- gzip.Writer (or flate.Writer, see newCompressor) :
 //   io.Writer <- bufio.Writer <- LineBreaker <-
 //       <- base6.Encoder <- gzip.Writer
 type GoZipWriter struct {
-	zw *gzip.Writer
+	zw io.WriteCloser
 	gw *GoWriter
 }
 
@@ -112,15 +124,21 @@ func NewGoZipWriter(w io.Writer,
 	if err != nil {
 		return nil, err
 	}
-	gzw = &GoZipWriter{}
-	gzw.gw = &GoWriter{}
-	gzw.gw.wb = bufio.NewWriter(w)
-	gzw.gw.wlb = NewLineBreaker(gzw.gw.wb, 76, "\n")
-	gzw.gw.w64 = base64.NewEncoder(base64.StdEncoding, gzw.gw.wlb)
-	gzw.zw = gzip.NewWriter(gzw.gw)
+	gzw = newGoZipWriter(w, FileFootFormat)
 	return gzw, nil
 }
 
+// newGoZipWriter returns a GoZipWriter that writes "foot" (instead of
+// the entry footer) on Close.
+func newGoZipWriter(w io.Writer, foot string) *GoZipWriter {
+	var gzw *GoZipWriter
+
+	gzw = &GoZipWriter{}
+	gzw.gw = newGoWriter(w, foot)
+	gzw.zw = newCompressor(gzw.gw)
+	return gzw
+}
+
 func (gzw *GoZipWriter) Write(p []byte) (int, error) {
 	return gzw.zw.Write(p)
 }
@@ -134,3 +152,261 @@ func (gzw *GoZipWriter) Close() error {
 	}
 	return gzw.gw.Close()
 }
+
+// Blob accumulates the data of all the entries, for the "blob"
+// layout.
+type Blob struct {
+	name string
+	buf  bytes.Buffer
+	seen map[[sha256.Size]byte][2]int // Data already in the blob
+}
+
+func NewBlob(name string) *Blob {
+	return &Blob{name: name, seen: make(map[[sha256.Size]byte][2]int)}
+}
+
+// Len returns the number of bytes in the blob
+func (b *Blob) Len() int {
+	if b == nil {
+		return 0
+	}
+	return b.buf.Len()
+}
+
+// truncate discards all but the first "n" bytes of the blob
+func (b *Blob) truncate(n int) {
+	if b == nil {
+		return
+	}
+	for sum, r := range b.seen {
+		if r[1] > n {
+			delete(b.seen, sum)
+		}
+	}
+	b.buf.Truncate(n)
+}
+
+// io.Writer <- Blob [ <- gzip.Writer ]
+//
+// The entry is emitted on Close, when its offset and length in the
+// blob are known. If the same data are already in the blob (and
+// "-dedup" is on), they are dropped, and the entry refers to the
+// existing copy.
+type BlobWriter struct {
+	w     io.Writer
+	b     *Blob
+	zw    io.WriteCloser
+	fname string
+	sz    int
+	off   int
+}
+
+func NewBlobWriter(w io.Writer, b *Blob,
+	fname string, sz int, zip bool) (*BlobWriter, error) {
+	var bw *BlobWriter
+
+	bw = &BlobWriter{w: w, b: b, fname: fname, sz: sz}
+	bw.off = b.buf.Len()
+	if zip {
+		bw.zw = newCompressor(&b.buf)
+	}
+	return bw, nil
+}
+
+func (bw *BlobWriter) Write(p []byte) (int, error) {
+	if bw.zw != nil {
+		return bw.zw.Write(p)
+	}
+	return bw.b.buf.Write(p)
+}
+
+func (bw *BlobWriter) Close() error {
+	var off, end int
+	var err error
+
+	if bw.zw != nil {
+		err = bw.zw.Close()
+		if err != nil {
+			return err
+		}
+	}
+	off, end = bw.off, bw.b.buf.Len()
+	if fl.dedup {
+		sum := sha256.Sum256(bw.b.buf.Bytes()[off:end])
+		if r, ok := bw.b.seen[sum]; ok {
+			dedupSaved += bw.sz
+			bw.b.buf.Truncate(off)
+			off, end = r[0], r[1]
+		} else {
+			bw.b.seen[sum] = [2]int{off, end}
+		}
+	}
+	_, err = fmt.Fprintf(bw.w, FileBlobFormat,
+		bw.fname, bw.sz, bw.zw != nil,
+		bw.b.name, off, end, dictField())
+	return err
+}
+
+// emitBlob emits the blob as a string constant, split in lines of
+// (about) BlobLineLen characters. The lines of large blobs are
+// grouped in several constants of BlobPartLines lines, which are then
+// concatenated, as the Go parser limits the nesting of expressions.
+func emitBlob(w io.Writer, b *Blob) error {
+	var wb *bufio.Writer
+	var lines []string
+	var line []byte
+	var parts []string
+	var err error
+
+	for _, c := range b.buf.Bytes() {
+		line = appendQuoted(line, c)
+		if len(line) >= BlobLineLen {
+			lines = append(lines, string(line))
+			line = line[:0]
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+
+	wb = bufio.NewWriter(w)
+	if len(lines) <= BlobPartLines {
+		err = emitBlobLines(wb, b.name, lines)
+		if err != nil {
+			return err
+		}
+		return wb.Flush()
+	}
+	for i := 0; i < len(lines); i += BlobPartLines {
+		end := i + BlobPartLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+		name := fmt.Sprintf("%s_%d", b.name, len(parts))
+		err = emitBlobLines(wb, name, lines[i:end])
+		if err != nil {
+			return err
+		}
+		parts = append(parts, name)
+	}
+	_, err = fmt.Fprintf(wb, BlobPartsFormat, b.name,
+		strings.Join(parts, " +\n\t"))
+	if err != nil {
+		return err
+	}
+	return wb.Flush()
+}
+
+// appendQuoted appends byte "c", quoted as in a Go string literal,
+// to "line".
+func appendQuoted(line []byte, c byte) []byte {
+	switch {
+	case c == '"' || c == '\\':
+		return append(line, '\\', c)
+	case c == '\n':
+		return append(line, `\n`...)
+	case c == '\t':
+		return append(line, `\t`...)
+	case c >= 0x20 && c < 0x7f:
+		return append(line, c)
+	default:
+		return append(line, '\\', 'x',
+			hexDigits[c>>4], hexDigits[c&0xf])
+	}
+}
+
+// blobLen returns (about) the size of the code emitted by emitBlob
+// for "data".
+func blobLen(data []byte) int {
+	var q [4]byte
+	var n int
+
+	for _, c := range data {
+		n += len(appendQuoted(q[:0], c))
+	}
+	// Line breaks, quotes, and concatenation operators
+	return n + (n+BlobLineLen-1)/BlobLineLen*len(" +\n\t\"\"")
+}
+
+// emitBlobLines emits a string constant named "name", as the
+// concatenation of the (quoted) "lines".
+func emitBlobLines(wb *bufio.Writer, name string, lines []string) error {
+	var err error
+
+	_, err = fmt.Fprintf(wb, BlobHeadFormat, name)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		wb.WriteString(" +\n\t\"")
+		wb.WriteString(l)
+		wb.WriteString("\"")
+	}
+	_, err = wb.WriteString("\n")
+	return err
+}
+
+// emitRegion emits the bundle container accumulated in "c" as a
+// reserved region (byte array) of "size" bytes. Trailing zeros are
+// omitted from the initializer.
+func emitRegion(w io.Writer, c *Container, size int) error {
+	var buf bytes.Buffer
+	var wb *bufio.Writer
+	var data []byte
+	var err error
+
+	_, err = c.WriteTo(&buf)
+	if err != nil {
+		return err
+	}
+	data, err = mkRegion(buf.Bytes(), size)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimRight(data, "\x00")
+
+	wb = bufio.NewWriter(w)
+	_, err = fmt.Fprintf(wb, RegionHeadFormat,
+		fl.pkg, fl.bundle, fl.index,
+		importSpec(),
+		generated(), size)
+	if err != nil {
+		return err
+	}
+	for i, b := range data {
+		if i%RegionLineBytes == 0 {
+			wb.WriteString("\n\t")
+		} else {
+			wb.WriteString(" ")
+		}
+		wb.WriteString("0x")
+		wb.WriteByte(hexDigits[b>>4])
+		wb.WriteByte(hexDigits[b&0xf])
+		wb.WriteString(",")
+	}
+	_, err = fmt.Fprintf(wb, RegionFootFormat, fl.bundle, fl.index)
+	if err != nil {
+		return err
+	}
+	return wb.Flush()
+}
+
+// mkRegion returns a reserved region of "size" bytes, holding
+// container "c". Returns an error if the container does not fit.
+func mkRegion(c []byte, size int) ([]byte, error) {
+	var le = binary.LittleEndian
+	var r []byte
+
+	if len(c) > size-bundle.RegionHeaderSize {
+		return nil, fmt.Errorf("bundle (%d bytes) does not fit "+
+			"in reserved region (%d bytes)",
+			len(c)+bundle.RegionHeaderSize, size)
+	}
+	r = make([]byte, 0, size)
+	r = append(r, bundle.RegionMagic...)
+	r = le.AppendUint64(r, uint64(size))
+	r = le.AppendUint64(r, uint64(len(c)))
+	r = append(r, c...)
+	r = r[:size]
+	return r, nil
+}
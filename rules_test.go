@@ -0,0 +1,74 @@
+package bundle_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRules checks the include / exclude rules of mkbundle (flags
+// and .bundleignore files), by listing the bundles it generates.
+func TestRules(t *testing.T) {
+	var files = map[string]string{
+		"docs/drafts/x.md":    "",
+		"docs/drafts/keep.md": "",
+		"docs/final/y.md":     "",
+		"a/b/c.txt":           "",
+		"a/b/.bundleignore":   "c.txt\n",
+		"a/keep.txt":          "",
+		"a/tmp.log":           "",
+		"build/out":           "",
+		"top.log":             "",
+		".bundleignore":       "# Comment\n*.log\n!a/tmp.log\nbuild/\n",
+	}
+	var tests = []struct {
+		args []string
+		exp  string
+	}{
+		{nil,
+			"a/keep.txt a/tmp.log docs/drafts/keep.md " +
+				"docs/drafts/x.md docs/final/y.md"},
+		{[]string{"-exclude=docs/drafts/*.md", "-include=keep.md"},
+			"a/keep.txt a/tmp.log docs/drafts/keep.md " +
+				"docs/final/y.md"},
+		{[]string{"-skip=docs", "-exclude=a/**"}, "a/tmp.log"},
+		{[]string{"-exclude=/a/", "-exclude=**/drafts"},
+			"docs/final/y.md"},
+		{[]string{"-exclude=*", "-include=*/", "-include=*.md"},
+			"a/tmp.log docs/drafts/keep.md docs/drafts/x.md " +
+				"docs/final/y.md"},
+		{[]string{"-ignorefile=", "-exclude=docs"},
+			".bundleignore a/b/.bundleignore a/b/c.txt " +
+				"a/keep.txt a/tmp.log build/out top.log"},
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	writeTree(t, data, files)
+	out := filepath.Join(dir, "bundle.go")
+	for _, tst := range tests {
+		args := append([]string{"-a", "-stable", "-o", out},
+			tst.args...)
+		b, err := exec.Command("mkbundle/mkbundle",
+			append(args, data)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mkbundle %v: %s\n%s", tst.args, err, b)
+		}
+		b, err = exec.Command("mkbundle/mkbundle", "ls",
+			out).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mkbundle ls: %s\n%s", err, b)
+		}
+		var names []string
+		for _, l := range strings.Split(string(b), "\n") {
+			if f := strings.Fields(l); len(f) == 3 {
+				names = append(names, f[2])
+			}
+		}
+		if s := strings.Join(names, " "); s != tst.exp {
+			t.Fatalf("mkbundle %v:\n%s\nExpected:\n%s",
+				tst.args, s, tst.exp)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package bundle_test
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestShard(t *testing.T) {
+	checkIndex(t, _shardBundleIdx)
+	parts, err := filepath.Glob("test_shard_bundle_*_test.go")
+	if err != nil {
+		t.Fatalf("Glob(): %s", err)
+	}
+	if len(parts) < 3 {
+		t.Fatalf("Bundle split in %d parts, expected >= 3", len(parts))
+	}
+	for _, fn := range parts {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			t.Fatalf("Stat(): %s", err)
+		}
+		// A part exceeds the max size (200000) only if it holds a
+		// single, larger, entry. The largest is about 320000 bytes.
+		if fi.Size() > 400000 {
+			t.Fatalf("Part %s too large: %d", fn, fi.Size())
+		}
+	}
+}
+
+var split_pkg_prog = `package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+)
+
+func main() {
+	for _, e := range _bundleIdx.Dir("") {
+		data, err := e.Decode(0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s %d %x\n", e.Name, e.Size, sha256.Sum256(data))
+	}
+}
+`
+
+// TestSplitPkg builds a program with a bundle split in packages
+// (-split=pkg). The program is built in a temporary GOPATH, so the
+// test runs only if package bundle is in GOPATH.
+func TestSplitPkg(t *testing.T) {
+	var gopath string
+	var out []byte
+	var err error
+
+	if testing.Short() {
+		t.Skip("Skipping in short mode")
+	}
+	for _, gp := range filepath.SplitList(build.Default.GOPATH) {
+		_, err = os.Stat(filepath.Join(gp,
+			"src/github.com/npat-efault/bundle/bundle.go"))
+		if err == nil {
+			gopath = gp
+			break
+		}
+	}
+	if gopath == "" {
+		t.Skip("Package bundle not in GOPATH")
+	}
+	gocmd := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err = os.Stat(gocmd); err != nil {
+		t.Skipf("Go command not found: %s", err)
+	}
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "src", "example.com", "app")
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		t.Fatalf("MkdirAll(): %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "main.go"),
+		[]byte(split_pkg_prog), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile(): %s", err)
+	}
+	out, err = exec.Command("mkbundle/mkbundle", "-split=pkg",
+		"-pkgpath=example.com/app", "-maxsize=200000", "-o",
+		filepath.Join(dir, "bundle.go"), data_dir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("mkbundle -split=pkg: %s\n%s", err, out)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "text")); err != nil {
+		t.Fatalf("Package for top-level directory: %s", err)
+	}
+	prog := filepath.Join(tmp, "prog")
+	cmd := exec.Command(gocmd, "build", "-o", prog, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=off", "GOFLAGS=",
+		"GOPATH="+strings.Join([]string{tmp, gopath},
+			string(filepath.ListSeparator)))
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build prog: %s\n%s", err, out)
+	}
+	runProg(t, prog, progOutput(t, data_dir))
+
+	// Directories that cannot name packages
+	for _, d := range []string{"func", "Main"} {
+		data := filepath.Join(tmp, "data_"+d)
+		err = os.MkdirAll(filepath.Join(data, d), 0777)
+		if err != nil {
+			t.Fatalf("MkdirAll(): %s", err)
+		}
+		err = ioutil.WriteFile(filepath.Join(data, d, "f.txt"),
+			[]byte("Test\n"), 0644)
+		if err != nil {
+			t.Fatalf("WriteFile(): %s", err)
+		}
+		out, err = exec.Command("mkbundle/mkbundle", "-split=pkg",
+			"-pkgpath=example.com/app", "-o",
+			filepath.Join(dir, "bundle.go"), data).CombinedOutput()
+		if err == nil {
+			t.Fatalf("mkbundle -split=pkg with %s succeeded", d)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Helpers for tests that bundle generated trees
+
+package bundle_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates, under "dir", the files in "files" (indexed by
+// slash-separated name, relative to "dir"), and the directories
+// leading to them.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for nm, s := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(nm))
+		err := os.MkdirAll(filepath.Dir(fn), 0777)
+		if err != nil {
+			t.Fatalf("MkdirAll(): %s", err)
+		}
+		err = ioutil.WriteFile(fn, []byte(s), 0644)
+		if err != nil {
+			t.Fatalf("WriteFile(): %s", err)
+		}
+	}
+}